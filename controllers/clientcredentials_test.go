@@ -0,0 +1,64 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/gin-gonic/gin"
+)
+
+func clientToken(t *testing.T, scope string) string {
+	t.Helper()
+	client := models.OAuthClient{Client_id: "billing-job", Name: "Billing job"}
+	token, err := helper.GenerateClientToken(client, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestUserRoutesCheckThePrincipal(t *testing.T) {
+	router, _ := newRouter(t)
+	signUp(t, router, "admin@example.com", "5550001", "ADMIN")
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	other := signUp(t, router, "other@example.com", "5550003", "USER")
+	userID := user["user"].(map[string]interface{})["user_id"].(string)
+	otherID := other["user"].(map[string]interface{})["user_id"].(string)
+	userToken := user["token"].(string)
+
+	reader := clientToken(t, "users:read")
+	writer := clientToken(t, "users:write")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"client lists users", http.MethodGet, "/users", reader, http.StatusOK},
+		{"client reads a user", http.MethodGet, "/users/" + userID, reader, http.StatusOK},
+		{"client unlocks a user", http.MethodPost, "/users/" + userID + "/unlock", writer, http.StatusOK},
+		{"client without users:read lists users", http.MethodGet, "/users", writer, http.StatusForbidden},
+		{"client without users:write unlocks a user", http.MethodPost, "/users/" + userID + "/unlock", reader, http.StatusForbidden},
+		{"user lists users", http.MethodGet, "/users", userToken, http.StatusBadRequest},
+		{"user unlocks a user", http.MethodPost, "/users/" + otherID + "/unlock", userToken, http.StatusBadRequest},
+		{"user reads themselves", http.MethodGet, "/users/" + userID, userToken, http.StatusOK},
+		{"user reads somebody else", http.MethodGet, "/users/" + otherID, userToken, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status, body := call(t, router, test.method, test.path, test.token, nil)
+			if status != test.want {
+				t.Fatalf("want %d, got %d %v", test.want, status, body)
+			}
+		})
+	}
+
+	// a client never passes for a user anywhere else
+	status, body := call(t, router, http.MethodPost, "/tokens/revoke", clientToken(t, "users:read users:write"), gin.H{"user_id": userID})
+	if status != http.StatusForbidden {
+		t.Fatalf("client at an admin route: %d %v", status, body)
+	}
+}
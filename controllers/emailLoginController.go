@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/mailer"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long an emailed login link (and its code) stays valid
+var emailLoginTTL = helper.EnvDuration("EMAIL_LOGIN_TTL", 15*time.Minute)
+
+// EmailLoginRateLimit is the extra per IP limit of the email login routes, on top
+// of the global one. The codes are short, guessing them must stay slow.
+var EmailLoginRateLimit = func() string {
+    if value := os.Getenv("EMAIL_LOGIN_RATE_LIMIT"); value != "" {
+        return value
+    }
+    return "5-M"
+}()
+
+type emailLoginRequest struct {
+    Email string `json:"email" validate:"required,email"`
+}
+
+// redeemEmailLoginRequest takes either the token from the link, or the email
+// together with the code from the same email.
+type redeemEmailLoginRequest struct {
+    Token string `json:"token" validate:"required_without=Code"`
+    Email string `json:"email" validate:"required_with=Code,omitempty,email"`
+    Code  string `json:"code" validate:"required_without=Token,omitempty,len=6,numeric"`
+}
+
+// RequestEmailLogin emails a single-use login link and a 6 digit code. Like
+// ForgotPassword it always answers 202, so it can't be used to probe for users.
+func RequestEmailLogin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req emailLoginRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        foundUser, err := findUserByEmail(ctx, req.Email)
+        // one email a minute at most, a new email also burns the code of the last one
+        if err == nil && time.Since(helper.LastActionTokenIssuedAt(foundUser.User_id, helper.MagicLinkTokenType)) > time.Minute {
+            if err := sendEmailLogin(foundUser); err != nil {
+                log.Println("Error sending login email:", err)
+            }
+        }
+
+        c.JSON(http.StatusAccepted, gin.H{"success": "if the account exists, a login email has been sent"})
+    }
+}
+
+func sendEmailLogin(user models.User) error {
+    token, code, err := helper.IssueActionTokenWithCode(user, helper.MagicLinkTokenType, emailLoginTTL)
+    if err != nil {
+        return err
+    }
+    link := helper.FrontendURL() + "/login/email?token=" + url.QueryEscape(token)
+
+    return mailer.SendTemplate(*user.Email, "magic_link", map[string]interface{}{
+        "Name":     *user.First_name,
+        "Link":     link,
+        "Code":     code,
+        "ValidFor": emailLoginTTL.String(),
+    })
+}
+
+// RedeemEmailLogin logs the user in with the link token or the code. Having the
+// email proves the address, so an unverified one is verified on the way. MFA
+// still applies, the email is only one factor.
+func RedeemEmailLogin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req redeemEmailLoginRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if req.Token != "" {
+            claims, msg := helper.ConsumeActionToken(req.Token, helper.MagicLinkTokenType)
+            if msg != "" {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+                return
+            }
+            // the email is part of the lookup, a link sent to an old address logs nobody in
+            user, err := findUserByEmail(ctx, claims.Email)
+            if err != nil || user.User_id != claims.Uid {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "the token is invalid"})
+                return
+            }
+            foundUser = user
+        } else {
+            user, err := findUserByEmail(ctx, req.Email)
+            if err != nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "the code is invalid or expired"})
+                return
+            }
+            if msg := helper.ConsumeActionCode(user.User_id, helper.MagicLinkTokenType, req.Code); msg != "" {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+                return
+            }
+            foundUser = user
+        }
+
+        if !foundUser.Email_verified {
+            now := time.Now()
+            err := userDB.WithContext(ctx).Model(&models.User{}).
+                Where("user_id = ?", foundUser.User_id).
+                Updates(map[string]interface{}{
+                    "email_verified":    true,
+                    "email_verified_at": now,
+                    "updated_at":        now,
+                }).Error
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+                return
+            }
+            foundUser.Email_verified = true
+            foundUser.Email_verified_at = &now
+        }
+
+        completeLogin(c, foundUser)
+    }
+}
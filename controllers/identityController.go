@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/mailer"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long a provider login that hit an existing account can be confirmed
+var linkIdentityTTL = helper.EnvDuration("LINK_IDENTITY_TTL", 15*time.Minute)
+
+// linkIdentityRequest confirms a pending link with the password of the account, or
+// with the code we emailed to it when the account has none.
+type linkIdentityRequest struct {
+    Link_token string `json:"link_token" validate:"required"`
+    Password   string `json:"password" validate:"required_without=Code"`
+    Code       string `json:"code" validate:"required_without=Password,omitempty,len=6,numeric"`
+}
+
+// providerLogin logs in the user behind an identity the provider vouched for.
+// extra is added to the token response, like the picture of the user.
+func providerLogin(c *gin.Context, identity helper.ExternalIdentity, extra gin.H) {
+    user, err := helper.FindOrCreateIdentityUser(identity)
+    if errors.Is(err, helper.ErrIdentityLinkRequired) {
+        startIdentityLink(c, user, identity)
+        return
+    }
+    if errors.Is(err, helper.ErrEmailNotVerified) {
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+        return
+    }
+    if err != nil {
+        log.Println("Error finding user for identity:", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+        return
+    }
+
+    if user.Mfa_enabled {
+        completeLogin(c, user)
+        return
+    }
+    token, refreshToken, err := helper.CreateSession(c, user)
+    if err != nil {
+        log.Println("Error creating session:", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens"})
+        return
+    }
+    response := gin.H{
+        "token":         token,
+        "refresh_token": refreshToken,
+        "user":          models.NewUserResponse(user),
+    }
+    for key, value := range extra {
+        response[key] = value
+    }
+    c.JSON(http.StatusOK, response)
+}
+
+// startIdentityLink answers a provider login for an email that already has an account.
+// Nothing is logged in, the client has to confirm at LinkIdentity with the link_token
+// and the password of the account, or the code we email to it.
+func startIdentityLink(c *gin.Context, user models.User, identity helper.ExternalIdentity) {
+    if err := helper.CreatePendingIdentity(user.User_id, identity); err != nil {
+        if errors.Is(err, helper.ErrProviderLinked) {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
+        log.Println("Error creating pending identity:", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+        return
+    }
+
+    linkToken, code, err := helper.IssueActionTokenWithCode(user, helper.LinkIdentityTokenType, linkIdentityTTL)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log in"})
+        return
+    }
+    err = mailer.SendTemplate(*user.Email, "link_identity", map[string]interface{}{
+        "Name":     *user.First_name,
+        "Provider": identity.Provider,
+        "Code":     code,
+        "ValidFor": linkIdentityTTL.String(),
+    })
+    if err != nil {
+        // the password still works for the link
+        log.Println("Error sending link identity email:", err)
+    }
+
+    c.JSON(http.StatusConflict, gin.H{
+        "error":         helper.ErrIdentityLinkRequired.Error(),
+        "link_required": true,
+        "link_token":    linkToken,
+        "has_password":  user.Password != nil,
+    })
+}
+
+// LinkIdentity confirms a pending link (see startIdentityLink) and logs the user in.
+func LinkIdentity() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req linkIdentityRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        claims, msg := helper.PeekActionToken(req.Link_token, helper.LinkIdentityTokenType)
+        if msg != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+        foundUser, err := findUserByEmail(ctx, claims.Email)
+        if err != nil || foundUser.User_id != claims.Uid {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the token is invalid"})
+            return
+        }
+
+        if req.Password != "" {
+            // a password guess like any other, so the login lockout applies
+            if wait := helper.LoginRetryAfter(*foundUser.Email); wait > 0 {
+                c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+                c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+                return
+            }
+            if foundUser.Password == nil {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "the account has no password, use the emailed code"})
+                return
+            }
+            isPasswordValid, err := VerifyPassword(req.Password, *foundUser.Password)
+            if err != nil {
+                respondHashError(c, err)
+                return
+            }
+            if !isPasswordValid {
+                recordLoginFailure(*foundUser.Email)
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "password is incorrect"})
+                return
+            }
+            if _, msg := helper.ConsumeActionToken(req.Link_token, helper.LinkIdentityTokenType); msg != "" {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+                return
+            }
+        } else if msg := helper.ConsumeActionCode(foundUser.User_id, helper.LinkIdentityTokenType, req.Code); msg != "" {
+            // the code belongs to the newest link token, which is the one we peeked
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+
+        identity, err := helper.ConfirmPendingIdentity(foundUser.User_id)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        log.Printf("Linked %s identity to user %s", identity.Provider, foundUser.User_id)
+
+        completeLogin(c, foundUser)
+    }
+}
+
+// GetIdentities lists the providers linked to the logged in user.
+func GetIdentities() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        identities, err := helper.ListIdentities(c.GetString("uid"))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing identities"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"identities": identities})
+    }
+}
+
+// UnlinkIdentity removes a provider from the logged in user.
+func UnlinkIdentity() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+
+        err := helper.UnlinkIdentity(foundUser, c.Param("provider"))
+        switch {
+        case errors.Is(err, helper.ErrIdentityNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        case errors.Is(err, helper.ErrLastLoginMethod):
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        case err != nil:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink identity"})
+        default:
+            c.JSON(http.StatusOK, gin.H{"message": "identity unlinked"})
+        }
+    }
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"net/http"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public signing keys, so other services can verify our
+// tokens without ever holding signing material.
+func JWKS() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Header("Cache-Control", "public, max-age=300")
+        c.JSON(http.StatusOK, helper.JWKS())
+    }
+}
+
+// RotateSigningKey makes a freshly generated key the active signing key. Only
+// admins can do this, the previous key keeps verifying until its tokens expire.
+func RotateSigningKey() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        kid, err := helper.RotateSigningKey()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"kid": kid})
+    }
+}
@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// how long the second login step may take once the password was accepted
+var mfaPendingTTL = helper.EnvDuration("MFA_PENDING_TTL", 5*time.Minute)
+
+type totpCodeRequest struct {
+    Code string `json:"code" validate:"required"`
+}
+
+type loginMFARequest struct {
+    Mfa_token     string `json:"mfa_token" validate:"required"`
+    Code          string `json:"code" validate:"required_without=Recovery_code"`
+    Recovery_code string `json:"recovery_code" validate:"required_without=Code"`
+}
+
+// EnrollTOTP starts the TOTP enrollment of the logged in user. MFA is only switched
+// on by ConfirmTOTP, once the user proved their app produces valid codes.
+func EnrollTOTP() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if foundUser.Mfa_enabled {
+            c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+            return
+        }
+
+        secret, err := helper.GenerateTOTPSecret()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+            return
+        }
+        encrypted, err := helper.EncryptSecret(secret)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+            return
+        }
+        err = userDB.WithContext(ctx).Model(&models.User{}).
+            Where("user_id = ?", foundUser.User_id).
+            Updates(map[string]interface{}{
+                "mfa_secret":    encrypted,
+                "mfa_last_step": 0,
+                "updated_at":    time.Now(),
+            }).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "secret":      secret,
+            "otpauth_uri": helper.TOTPURI(secret, *foundUser.Email),
+        })
+    }
+}
+
+// ConfirmTOTP finishes the enrollment with a code from the app, switches MFA on and
+// returns the recovery codes. They are never shown again.
+func ConfirmTOTP() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req totpCodeRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if foundUser.Mfa_enabled {
+            c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+            return
+        }
+        if foundUser.Mfa_secret == nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "start the enrollment first"})
+            return
+        }
+        if !helper.VerifyUserTOTP(foundUser, req.Code) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "the code is incorrect"})
+            return
+        }
+
+        codes, err := helper.GenerateRecoveryCodes(foundUser.User_id)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+            return
+        }
+        err = userDB.WithContext(ctx).Model(&models.User{}).
+            Where("user_id = ?", foundUser.User_id).
+            Updates(map[string]interface{}{
+                "mfa_enabled": true,
+                "updated_at":  time.Now(),
+            }).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable MFA"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "success":        "MFA enabled",
+            "recovery_codes": codes,
+        })
+    }
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of the user, it takes a
+// current TOTP code so a stolen access token alone can't do it.
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req totpCodeRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if !foundUser.Mfa_enabled {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+            return
+        }
+        if !helper.VerifyUserTOTP(foundUser, req.Code) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "the code is incorrect"})
+            return
+        }
+
+        codes, err := helper.GenerateRecoveryCodes(foundUser.User_id)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+    }
+}
+
+// LoginMFA is the second step of a login with MFA: the mfa_token from Login plus a
+// TOTP code (or a recovery code) give the token pair.
+func LoginMFA() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req loginMFARequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        // the token is only used up once the code was right, a typo doesn't mean a new
+        // login. Every try counts against it though, see ReserveActionTokenAttempt.
+        claims, msg := helper.PeekActionToken(req.Mfa_token, helper.MfaPendingTokenType)
+        if msg != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", claims.Uid).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the token is invalid"})
+            return
+        }
+
+        // wrong codes count towards the same lockout as wrong passwords, so
+        // getting new mfa_tokens doesn't give an attacker more guesses
+        if wait := helper.LoginRetryAfter(*foundUser.Email); wait > 0 {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+            return
+        }
+
+        if msg := helper.ReserveActionTokenAttempt(claims); msg != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+        valid := false
+        if req.Code != "" {
+            valid = helper.VerifyUserTOTP(foundUser, req.Code)
+        } else {
+            valid = helper.UseRecoveryCode(foundUser.User_id, req.Recovery_code)
+            if valid {
+                log.Printf("Recovery code used by user %s", foundUser.User_id)
+            }
+        }
+        if !valid {
+            recordLoginFailure(*foundUser.Email)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the code is incorrect"})
+            return
+        }
+
+        if _, msg := helper.ConsumeActionToken(req.Mfa_token, helper.MfaPendingTokenType); msg != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+        if err := helper.ResetLoginFailures(*foundUser.Email); err != nil {
+            log.Println("Error resetting failed logins:", err)
+        }
+
+        issueSession(c, foundUser)
+    }
+}
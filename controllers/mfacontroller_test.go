@@ -0,0 +1,68 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/gin-gonic/gin"
+)
+
+// startMFALogin turns MFA on for the user and returns an mfa_token from Login
+// plus a valid recovery code.
+func startMFALogin(t *testing.T, router *gin.Engine, email string, userID string) (string, string) {
+	t.Helper()
+	codes, err := helper.GenerateRecoveryCodes(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.ResetLoginFailures(email); err != nil {
+		t.Fatal(err)
+	}
+	status, login := call(t, router, http.MethodPost, "/user/login", "", gin.H{"email": email, "password": testPassword})
+	if status != http.StatusOK || login["mfa_token"] == nil {
+		t.Fatalf("login: %d %v", status, login)
+	}
+	return login["mfa_token"].(string), codes[0]
+}
+
+func TestLoginMFABurnsTheTokenAfterWrongCodes(t *testing.T) {
+	router, db := newRouter(t)
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	userID := user["user"].(map[string]interface{})["user_id"].(string)
+	if err := db.Model(&models.User{}).Where("user_id = ?", userID).Update("mfa_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	mfaToken, recoveryCode := startMFALogin(t, router, "user@example.com", userID)
+
+	for i := 0; i < 5; i++ {
+		status, body := call(t, router, http.MethodPost, "/users/login/mfa", "", gin.H{"mfa_token": mfaToken, "code": "000000"})
+		if status != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: %d %v", i+1, status, body)
+		}
+		if i == 0 {
+			// a wrong code feeds the login lockout, the next try has to wait
+			status, body = call(t, router, http.MethodPost, "/users/login/mfa", "", gin.H{"mfa_token": mfaToken, "code": "000000"})
+			if status != http.StatusTooManyRequests {
+				t.Fatalf("retry right after a wrong code: %d %v", status, body)
+			}
+		}
+		// as if the backoff ran out
+		if err := helper.ResetLoginFailures("user@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, body := call(t, router, http.MethodPost, "/users/login/mfa", "", gin.H{"mfa_token": mfaToken, "recovery_code": recoveryCode})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the token still works after 5 wrong codes: %d %v", status, body)
+	}
+
+	// a new login starts over
+	mfaToken, recoveryCode = startMFALogin(t, router, "user@example.com", userID)
+	status, body = call(t, router, http.MethodPost, "/users/login/mfa", "", gin.H{"mfa_token": mfaToken, "recovery_code": recoveryCode})
+	if status != http.StatusOK || body["token"] == nil {
+		t.Fatalf("the right code: %d %v", status, body)
+	}
+}
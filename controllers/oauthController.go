@@ -0,0 +1,183 @@
+// controllers/oauthController.go
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth/gothic"
+)
+
+// the browser session the login code is bound to, a cookie of its own next to gothic's
+const oauthLoginSession = "oauth_login"
+
+type exchangeOAuthCodeRequest struct {
+    Code string `json:"code" validate:"required"`
+}
+
+type googleTokenRequest struct {
+    Id_token string `json:"id_token" validate:"required"`
+}
+
+// OAuthBegin sends the browser to the provider named in the path.
+func OAuthBegin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        provider := c.Param("provider")
+        if !helper.OAuthProviderEnabled(provider) {
+            c.JSON(http.StatusNotFound, gin.H{"error": helper.ErrUnknownOAuthProvider.Error()})
+            return
+        }
+        log.Printf("Starting %s OAuth flow...", provider)
+
+        // gothic reads the provider from the query
+        setGothicProvider(c, provider)
+        gothic.BeginAuthHandler(c.Writer, c.Request)
+    }
+}
+
+// OAuthCallback finishes the login on the server. The identity never travels
+// through the browser, the frontend only gets a short-lived code to exchange
+// at ExchangeOAuthCode.
+func OAuthCallback() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        provider := c.Param("provider")
+        if !helper.OAuthProviderEnabled(provider) {
+            c.JSON(http.StatusNotFound, gin.H{"error": helper.ErrUnknownOAuthProvider.Error()})
+            return
+        }
+        log.Printf("Received %s callback...", provider)
+        failed := helper.FrontendURL() + "/signup?error=" + url.QueryEscape(provider+"_auth_failed")
+
+        setGothicProvider(c, provider)
+        user, err := gothic.CompleteUserAuth(c.Writer, c.Request)
+        if err != nil {
+            log.Printf("❌ Error in %s callback: %v", provider, err)
+            c.Redirect(http.StatusFound, failed)
+            return
+        }
+
+        login, err := helper.MapOAuthUser(provider, user)
+        if err != nil || login.Identity.Subject == "" || login.Identity.Email == "" {
+            log.Printf("❌ %s returned no usable user id or email", provider)
+            c.Redirect(http.StatusFound, failed)
+            return
+        }
+        if err := helper.CheckIdentityEmail(login.Identity); err != nil {
+            log.Printf("❌ %s did not verify %s: %v", provider, login.Identity.Email, err)
+            c.Redirect(http.StatusFound, helper.FrontendURL()+"/signup?error="+url.QueryEscape(provider+"_email_not_verified"))
+            return
+        }
+        log.Printf("✅ %s user authenticated: %s", provider, login.Identity.Email)
+
+        redirectWithLoginCode(c, login)
+    }
+}
+
+func setGothicProvider(c *gin.Context, provider string) {
+    q := c.Request.URL.Query()
+    q.Set("provider", provider)
+    c.Request.URL.RawQuery = q.Encode()
+}
+
+// redirectWithLoginCode sends the browser back to the frontend with a login code
+// that only this browser can exchange.
+func redirectWithLoginCode(c *gin.Context, login helper.OAuthLogin) {
+    failed := helper.FrontendURL() + "/signup?error=" + url.QueryEscape(login.Identity.Provider+"_auth_failed")
+
+    binding, err := helper.RandomToken(32)
+    if err != nil {
+        c.Redirect(http.StatusFound, failed)
+        return
+    }
+    // a cookie we can't decode (old key) just gets replaced
+    session, _ := gothic.Store.Get(c.Request, oauthLoginSession)
+    session.Values["binding"] = binding
+    session.Options.MaxAge = int(helper.OAuthCodeTTL.Seconds())
+    if err := session.Save(c.Request, c.Writer); err != nil {
+        log.Println("Error saving login session:", err)
+        c.Redirect(http.StatusFound, failed)
+        return
+    }
+
+    code, err := helper.IssueOAuthLoginCode(login, binding)
+    if err != nil {
+        log.Println("Error issuing login code:", err)
+        c.Redirect(http.StatusFound, failed)
+        return
+    }
+    c.Redirect(http.StatusFound, helper.FrontendURL()+"/auth/callback?code="+url.QueryEscape(code))
+}
+
+// ExchangeOAuthCode trades the code from the login redirect for the token pair.
+// The request has to come with the cookies of the browser that did the login.
+func ExchangeOAuthCode() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var req exchangeOAuthCodeRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        session, _ := gothic.Store.Get(c.Request, oauthLoginSession)
+        binding, _ := session.Values["binding"].(string)
+        login, err := helper.RedeemOAuthLoginCode(req.Code, binding)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrInvalidOAuthCode.Error()})
+            return
+        }
+
+        // the binding did its job
+        session.Options.MaxAge = -1
+        if err := session.Save(c.Request, c.Writer); err != nil {
+            log.Println("Error clearing login session:", err)
+        }
+
+        oauthLogin(c, login)
+    }
+}
+
+// GoogleTokenSignIn logs in with a Google ID token, for the mobile apps and SPAs
+// that sign in with Google themselves and can't do the redirect flow.
+func GoogleTokenSignIn() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if !helper.GoogleIDTokensEnabled() {
+            c.JSON(http.StatusNotFound, gin.H{"error": "Google ID token sign-in is not configured"})
+            return
+        }
+
+        var req googleTokenRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        login, err := helper.VerifyGoogleIDToken(req.Id_token)
+        if err != nil {
+            log.Println("Rejected Google ID token:", err)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": helper.ErrInvalidGoogleIDToken.Error()})
+            return
+        }
+
+        oauthLogin(c, login)
+    }
+}
+
+// oauthLogin is providerLogin for a login the provider vouched for.
+func oauthLogin(c *gin.Context, login helper.OAuthLogin) {
+    extra := gin.H{}
+    for key, value := range login.Extra {
+        extra[key] = value
+    }
+    providerLogin(c, login.Identity, extra)
+}
@@ -0,0 +1,818 @@
+package controllers
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"github.com/markbates/goth/gothic"
+)
+
+// the pages of /authorize: login, consent and the error page for requests we
+// can't send back to the client.
+//
+//go:embed templates/*.html
+var pageFS embed.FS
+
+var pages = template.Must(template.ParseFS(pageFS, "templates/*.html"))
+
+// the browser session of whoever logged in at /authorize, a cookie of its own next
+// to gothic's. It holds the CSRF token of the forms and the handle of the login
+// (see helpers.StartOIDCLogin), never the user itself.
+const oidcSession = "oidc_session"
+
+// how long a login at /authorize lasts, after that the user logs in again
+var oidcSessionTTL = helper.EnvDuration("OIDC_SESSION_TTL", 12*time.Hour)
+
+// what the consent page tells the user about each scope
+var scopeDescriptions = map[string]string{
+    "openid":         "Know who you are",
+    "profile":        "See your name",
+    "email":          "See your email address",
+    "phone":          "See your phone number",
+    "offline_access": "Stay signed in while you are not using it",
+}
+
+// authorizeRequest is an authentication request of a client, the parameters of
+// /authorize once the client and the redirect URI checked out.
+type authorizeRequest struct {
+    Client                models.OAuthClient
+    Redirect_uri          string
+    Response_type         string
+    Scope                 string // limited to the scopes the client may ask for
+    State                 string
+    Nonce                 string
+    Code_challenge        string
+    Code_challenge_method string
+    Prompt                string
+    Max_age               int // -1 when not given
+    Values                url.Values // carried through the login and consent forms
+}
+
+// registerClientRequest registers an app that logs users in through us, or with
+// "machine": true a backend job that calls our API as itself (client_credentials).
+type registerClientRequest struct {
+    Name          string   `json:"name" validate:"required,max=100"`
+    Redirect_uris []string `json:"redirect_uris" validate:"required_without=Machine,omitempty,dive,url"`
+    Scopes        []string `json:"scopes"`
+    Public        bool     `json:"public"` // SPAs and mobile apps, no secret and PKCE is required
+    Skip_consent  bool     `json:"skip_consent"`
+    Machine       bool     `json:"machine"`
+}
+
+// RequireOIDC answers 404 on the provider routes while ID tokens can't be issued.
+func RequireOIDC() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if !helper.OIDCEnabled() {
+            c.JSON(http.StatusNotFound, gin.H{"error": "the OpenID Connect provider needs an RS256, ES256 or EdDSA signing key"})
+            c.Abort()
+            return
+        }
+        c.Next()
+    }
+}
+
+// OpenIDConfiguration serves the discovery document.
+func OpenIDConfiguration() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Header("Cache-Control", "public, max-age=3600")
+        c.JSON(http.StatusOK, helper.OpenIDConfiguration())
+    }
+}
+
+// Authorize is the authorization endpoint. The user logs in (or already is), agrees
+// to share the scopes with the client, and the browser goes back to the client with
+// an authorization code.
+func Authorize() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        // the parameters can come in the query or, with POST, in the form
+        if err := c.Request.ParseForm(); err != nil {
+            renderPage(c, http.StatusBadRequest, "authorize_error.html", gin.H{"Error": "The request is malformed."})
+            return
+        }
+        req, ok := parseAuthorizeRequest(c, c.Request.Form)
+        if !ok {
+            return
+        }
+        authorize(c, req, false)
+    }
+}
+
+// AuthorizeLogin takes the login form of Authorize.
+func AuthorizeLogin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        session, ok := checkAuthorizeForm(c)
+        if !ok {
+            return
+        }
+        values, _ := url.ParseQuery(c.PostForm("request"))
+        req, ok := parseAuthorizeRequest(c, values)
+        if !ok {
+            return
+        }
+
+        email := strings.TrimSpace(c.PostForm("email"))
+        password := c.PostForm("password")
+        failed := func(status int, msg string, mfa bool) {
+            renderLogin(c, session, req, status, gin.H{"Error": msg, "Email": email, "MFA": mfa})
+        }
+        if email == "" || password == "" {
+            failed(http.StatusBadRequest, "Enter your email and password.", false)
+            return
+        }
+
+        // the same checks as Login, see there
+        if wait := helper.LoginRetryAfter(email); wait > 0 {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            failed(http.StatusTooManyRequests, "Too many failed login attempts, try again later.", false)
+            return
+        }
+        foundUser, err := findUserByEmail(ctx, email)
+        if err != nil || foundUser.Password == nil {
+            recordLoginFailure(email)
+            failed(http.StatusUnauthorized, "The email or password is incorrect.", false)
+            return
+        }
+        isPasswordValid, err := VerifyPassword(password, *foundUser.Password)
+        if errors.Is(err, helper.ErrHashPoolBusy) {
+            retryAfter := int(math.Ceil(helper.HashQueueTimeout.Seconds()))
+            c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+            failed(http.StatusServiceUnavailable, "We are busy right now, try again in a moment.", false)
+            return
+        }
+        if err != nil {
+            failed(http.StatusInternalServerError, "Something went wrong, try again.", false)
+            return
+        }
+        if !isPasswordValid {
+            recordLoginFailure(email)
+            failed(http.StatusUnauthorized, "The email or password is incorrect.", false)
+            return
+        }
+        if helper.PasswordNeedsRehash(*foundUser.Password) {
+            rehashPassword(ctx, foundUser, password)
+        }
+        if requireEmailVerification && !foundUser.Email_verified {
+            failed(http.StatusForbidden, "Your email address is not verified yet.", false)
+            return
+        }
+
+        // with MFA the form comes back with a code field, the password is asked again
+        // so nothing has to be kept between the two posts
+        if foundUser.Mfa_enabled {
+            code := strings.TrimSpace(c.PostForm("code"))
+            if code == "" {
+                failed(http.StatusOK, "Enter the code from your authenticator app.", true)
+                return
+            }
+            // counted like a wrong password, the LoginRetryAfter above limits the guesses
+            if !helper.VerifyUserTOTP(foundUser, code) && !helper.UseRecoveryCode(foundUser.User_id, code) {
+                recordLoginFailure(email)
+                failed(http.StatusUnauthorized, "The code is incorrect.", true)
+                return
+            }
+        }
+
+        if err := helper.ResetLoginFailures(email); err != nil {
+            log.Println("Error resetting failed logins:", err)
+        }
+
+        // a new CSRF token with the login, one picked before it is no good anymore
+        csrf, err := helper.RandomToken(32)
+        if err != nil {
+            failed(http.StatusInternalServerError, "Something went wrong, try again.", false)
+            return
+        }
+        // the login itself stays on our side, the cookie only gets a handle for it
+        handle, err := helper.StartOIDCLogin(foundUser.User_id, time.Now(), oidcSessionTTL)
+        if err != nil {
+            log.Println("Error saving OIDC login:", err)
+            failed(http.StatusInternalServerError, "Something went wrong, try again.", false)
+            return
+        }
+        if previous, _ := session.Values["login"].(string); previous != "" {
+            if err := helper.EndOIDCLogin(previous); err != nil {
+                log.Println("Error ending OIDC login:", err)
+            }
+        }
+        session.Values["login"] = handle
+        session.Values["csrf"] = csrf
+        session.Options.MaxAge = int(oidcSessionTTL.Seconds())
+        if err := session.Save(c.Request, c.Writer); err != nil {
+            log.Println("Error saving OIDC session:", err)
+            failed(http.StatusInternalServerError, "Something went wrong, try again.", false)
+            return
+        }
+
+        authorize(c, req, true)
+    }
+}
+
+// AuthorizeConsent takes the answer of the consent page of Authorize.
+func AuthorizeConsent() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        session, ok := checkAuthorizeForm(c)
+        if !ok {
+            return
+        }
+        values, _ := url.ParseQuery(c.PostForm("request"))
+        req, ok := parseAuthorizeRequest(c, values)
+        if !ok {
+            return
+        }
+
+        // the login may have run out while the page was open
+        user, authTime, ok := loggedInUser(c, session, req, true)
+        if !ok {
+            renderLogin(c, session, req, http.StatusOK, gin.H{})
+            return
+        }
+        if c.PostForm("decision") != "allow" {
+            redirectAuthorizeError(c, req, "access_denied", "the user did not allow the request")
+            return
+        }
+        if err := helper.SaveConsent(user.User_id, req.Client.Client_id, req.Scope); err != nil {
+            log.Println("Error saving consent:", err)
+            redirectAuthorizeError(c, req, "server_error", "")
+            return
+        }
+        redirectWithAuthorizationCode(c, req, user, authTime)
+    }
+}
+
+// EndSession logs the browser out of /authorize (RP-initiated logout). Tokens the
+// clients got stay valid, LogoutAll is for those. With client_id and one of its
+// redirect URIs as post_logout_redirect_uri the browser goes back there, with state.
+func EndSession() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        session, _ := gothic.Store.Get(c.Request, oidcSession)
+        handle, _ := session.Values["login"].(string)
+        if err := helper.EndOIDCLogin(handle); err != nil {
+            log.Println("Error ending OIDC login:", err)
+        }
+        session.Values = map[interface{}]interface{}{}
+        session.Options.MaxAge = -1
+        if err := session.Save(c.Request, c.Writer); err != nil {
+            log.Println("Error clearing OIDC session:", err)
+        }
+
+        redirectURI := c.Request.FormValue("post_logout_redirect_uri")
+        if redirectURI == "" {
+            renderPage(c, http.StatusOK, "logout.html", gin.H{})
+            return
+        }
+        // only back to an address the client registered, or this is an open redirect
+        client, err := helper.FindOAuthClient(c.Request.FormValue("client_id"))
+        if err != nil || !helper.ClientAllowsRedirect(client, redirectURI) {
+            renderPage(c, http.StatusBadRequest, "authorize_error.html", gin.H{"Error": "You are logged out, but the app asked to go back to an address it did not register."})
+            return
+        }
+        redirectToClient(c, authorizeRequest{Redirect_uri: redirectURI, State: c.Request.FormValue("state")}, url.Values{})
+    }
+}
+
+// authorize goes on with a valid request: the login page, the consent page or the
+// code, whichever is due. fresh is set right after the user logged in.
+func authorize(c *gin.Context, req authorizeRequest, fresh bool) {
+    session, _ := gothic.Store.Get(c.Request, oidcSession)
+    none := helper.ScopeIncludes(req.Prompt, "none")
+
+    user, authTime, ok := loggedInUser(c, session, req, fresh)
+    if !ok {
+        if none {
+            redirectAuthorizeError(c, req, "login_required", "")
+            return
+        }
+        renderLogin(c, session, req, http.StatusOK, gin.H{})
+        return
+    }
+
+    asked := helper.ScopeIncludes(req.Prompt, "consent")
+    if !req.Client.Skip_consent && (asked || !helper.HasConsent(user.User_id, req.Client.Client_id, req.Scope)) {
+        if none {
+            redirectAuthorizeError(c, req, "consent_required", "")
+            return
+        }
+        renderConsent(c, session, req, user)
+        return
+    }
+
+    redirectWithAuthorizationCode(c, req, user, authTime)
+}
+
+// parseAuthorizeRequest checks the parameters of an authentication request. Until
+// the client and the redirect URI are known to be good, errors are shown on our
+// own page, after that they go back to the client. ok is false when the answer
+// has been written already.
+func parseAuthorizeRequest(c *gin.Context, values url.Values) (req authorizeRequest, ok bool) {
+    client, err := helper.FindOAuthClient(values.Get("client_id"))
+    if err != nil || !client.AllowsGrant("authorization_code") {
+        renderPage(c, http.StatusBadRequest, "authorize_error.html", gin.H{"Error": "The app you came from is unknown."})
+        return req, false
+    }
+    redirectURI := values.Get("redirect_uri")
+    if !helper.ClientAllowsRedirect(client, redirectURI) {
+        renderPage(c, http.StatusBadRequest, "authorize_error.html", gin.H{"Error": "The app you came from asked to go back to an address it did not register."})
+        return req, false
+    }
+
+    req = authorizeRequest{
+        Client:                client,
+        Redirect_uri:          redirectURI,
+        Response_type:         values.Get("response_type"),
+        Scope:                 helper.GrantableScope(client, values.Get("scope")),
+        State:                 values.Get("state"),
+        Nonce:                 values.Get("nonce"),
+        Code_challenge:        values.Get("code_challenge"),
+        Code_challenge_method: values.Get("code_challenge_method"),
+        Prompt:                values.Get("prompt"),
+        Max_age:               -1,
+        Values:                values,
+    }
+
+    if req.Response_type != "code" {
+        redirectAuthorizeError(c, req, "unsupported_response_type", "only the authorization code flow is supported")
+        return req, false
+    }
+    if !helper.ScopeIncludes(values.Get("scope"), "openid") || !helper.ScopeIncludes(req.Scope, "openid") {
+        redirectAuthorizeError(c, req, "invalid_scope", "the scope must include openid")
+        return req, false
+    }
+    if helper.ScopeIncludes(req.Prompt, "none") && len(strings.Fields(req.Prompt)) > 1 {
+        redirectAuthorizeError(c, req, "invalid_request", "prompt=none can't be combined with other values")
+        return req, false
+    }
+    if maxAge := values.Get("max_age"); maxAge != "" {
+        seconds, err := strconv.Atoi(maxAge)
+        if err != nil || seconds < 0 {
+            redirectAuthorizeError(c, req, "invalid_request", "max_age must be a number of seconds")
+            return req, false
+        }
+        req.Max_age = seconds
+    }
+    // a public client has no secret, only PKCE ties the code to the app that asked for it
+    if req.Code_challenge == "" && !req.Client.Confidential() {
+        redirectAuthorizeError(c, req, "invalid_request", "code_challenge is required")
+        return req, false
+    }
+    if req.Code_challenge != "" && req.Code_challenge_method != "S256" {
+        redirectAuthorizeError(c, req, "invalid_request", "code_challenge_method must be S256")
+        return req, false
+    }
+    return req, true
+}
+
+// loggedInUser returns the user logged in at /authorize, as long as the login is
+// recent enough for the request. A login from before the user's tokens were revoked
+// (LogoutAll, a password reset, an admin) is over as well.
+func loggedInUser(c *gin.Context, session *sessions.Session, req authorizeRequest, fresh bool) (models.User, time.Time, bool) {
+    var user models.User
+    handle, _ := session.Values["login"].(string)
+    login, found := helper.FindOIDCLogin(handle)
+    if !found {
+        return user, time.Time{}, false
+    }
+
+    authTime := time.UnixMicro(login.Auth_us)
+    age := time.Since(authTime)
+    if helper.RevokedSince(login.User_id, login.Auth_us) {
+        return user, authTime, false
+    }
+    if req.Max_age >= 0 && age > time.Duration(req.Max_age)*time.Second && !fresh {
+        return user, authTime, false
+    }
+    if helper.ScopeIncludes(req.Prompt, "login") && !fresh {
+        return user, authTime, false
+    }
+
+    if err := userDB.WithContext(c.Request.Context()).Where("user_id = ?", login.User_id).First(&user).Error; err != nil {
+        return user, authTime, false
+    }
+    return user, authTime, true
+}
+
+// checkAuthorizeForm checks the CSRF token of the login and consent forms.
+func checkAuthorizeForm(c *gin.Context) (*sessions.Session, bool) {
+    session, _ := gothic.Store.Get(c.Request, oidcSession)
+    csrf, _ := session.Values["csrf"].(string)
+    if csrf == "" || c.PostForm("csrf") != csrf {
+        renderPage(c, http.StatusBadRequest, "authorize_error.html", gin.H{"Error": "The form has expired."})
+        return session, false
+    }
+    return session, true
+}
+
+// csrfToken returns the CSRF token of the session, a new one is saved if there is none.
+func csrfToken(c *gin.Context, session *sessions.Session) (string, error) {
+    if csrf, _ := session.Values["csrf"].(string); csrf != "" {
+        return csrf, nil
+    }
+    csrf, err := helper.RandomToken(32)
+    if err != nil {
+        return "", err
+    }
+    session.Values["csrf"] = csrf
+    session.Options.MaxAge = int(oidcSessionTTL.Seconds())
+    return csrf, session.Save(c.Request, c.Writer)
+}
+
+func renderLogin(c *gin.Context, session *sessions.Session, req authorizeRequest, status int, data gin.H) {
+    csrf, err := csrfToken(c, session)
+    if err != nil {
+        log.Println("Error saving OIDC session:", err)
+        redirectAuthorizeError(c, req, "server_error", "")
+        return
+    }
+    data["Client"] = req.Client.Name
+    data["CSRF"] = csrf
+    data["Request"] = req.Values.Encode()
+    renderPage(c, status, "authorize_login.html", data)
+}
+
+func renderConsent(c *gin.Context, session *sessions.Session, req authorizeRequest, user models.User) {
+    csrf, err := csrfToken(c, session)
+    if err != nil {
+        log.Println("Error saving OIDC session:", err)
+        redirectAuthorizeError(c, req, "server_error", "")
+        return
+    }
+    var scopes []string
+    for _, scope := range strings.Fields(req.Scope) {
+        scopes = append(scopes, scopeDescriptions[scope])
+    }
+    renderPage(c, http.StatusOK, "authorize_consent.html", gin.H{
+        "Client":  req.Client.Name,
+        "Email":   *user.Email,
+        "Scopes":  scopes,
+        "CSRF":    csrf,
+        "Request": req.Values.Encode(),
+    })
+}
+
+func renderPage(c *gin.Context, status int, name string, data gin.H) {
+    c.Header("Cache-Control", "no-store")
+    // nobody gets to frame the login or the consent page and click for the user
+    c.Header("X-Frame-Options", "DENY")
+    c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+    c.Status(status)
+    c.Header("Content-Type", "text/html; charset=utf-8")
+    if err := pages.ExecuteTemplate(c.Writer, name, data); err != nil {
+        log.Println("Error rendering page:", err)
+    }
+}
+
+// redirectAuthorizeError sends the browser back to the client with an OAuth error.
+func redirectAuthorizeError(c *gin.Context, req authorizeRequest, code string, description string) {
+    params := url.Values{"error": {code}}
+    if description != "" {
+        params.Set("error_description", description)
+    }
+    redirectToClient(c, req, params)
+}
+
+// redirectWithAuthorizationCode finishes Authorize, the client gets a code to
+// exchange at Token.
+func redirectWithAuthorizationCode(c *gin.Context, req authorizeRequest, user models.User, authTime time.Time) {
+    code, err := helper.IssueAuthorizationCode(models.OAuthAuthorizationCode{
+        Client_id:      req.Client.Client_id,
+        User_id:        user.User_id,
+        Redirect_uri:   req.Redirect_uri,
+        Scope:          req.Scope,
+        Nonce:          req.Nonce,
+        Code_challenge: req.Code_challenge,
+        Auth_time:      authTime,
+    })
+    if err != nil {
+        log.Println("Error issuing authorization code:", err)
+        redirectAuthorizeError(c, req, "server_error", "")
+        return
+    }
+    redirectToClient(c, req, url.Values{"code": {code}})
+}
+
+func redirectToClient(c *gin.Context, req authorizeRequest, params url.Values) {
+    // the redirect URI was registered exactly like this, so it parses
+    target, _ := url.Parse(req.Redirect_uri)
+    query := target.Query()
+    for key, values := range params {
+        query[key] = values
+    }
+    if req.State != "" {
+        query.Set("state", req.State)
+    }
+    target.RawQuery = query.Encode()
+    c.Header("Cache-Control", "no-store")
+    c.Redirect(http.StatusFound, target.String())
+}
+
+// Token is the token endpoint of the clients: codes from Authorize, refresh tokens
+// of the sessions they were granted, and the client credentials of machine clients.
+func Token() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        c.Header("Cache-Control", "no-store")
+        c.Header("Pragma", "no-cache")
+
+        clientID, secret, basic := c.Request.BasicAuth()
+        if basic {
+            // client_secret_basic credentials are form encoded before they are joined
+            clientID, _ = url.QueryUnescape(clientID)
+            secret, _ = url.QueryUnescape(secret)
+        } else {
+            clientID = c.PostForm("client_id")
+            secret = c.PostForm("client_secret")
+        }
+        client, err := helper.AuthenticateOAuthClient(clientID, secret)
+        if err != nil {
+            if basic {
+                c.Header("WWW-Authenticate", `Basic realm="token"`)
+            }
+            tokenError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+            return
+        }
+
+        grantType := c.PostForm("grant_type")
+        if grantType != "" && !client.AllowsGrant(grantType) {
+            tokenError(c, http.StatusBadRequest, "unauthorized_client", "the client may not use this grant type")
+            return
+        }
+
+        switch grantType {
+        case "authorization_code":
+            // ID tokens need a key the client can check, see RequireOIDC
+            if !helper.OIDCEnabled() {
+                tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+                return
+            }
+            code, err := helper.RedeemAuthorizationCode(c.PostForm("code"), client, c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+            if errors.Is(err, helper.ErrInvalidGrant) {
+                tokenError(c, http.StatusBadRequest, "invalid_grant", "the code is invalid, expired or was issued to another client")
+                return
+            }
+            if err != nil {
+                log.Println("Error redeeming authorization code:", err)
+                tokenError(c, http.StatusInternalServerError, "server_error", "")
+                return
+            }
+
+            var foundUser models.User
+            if err := userDB.WithContext(ctx).Where("user_id = ?", code.User_id).First(&foundUser).Error; err != nil {
+                tokenError(c, http.StatusBadRequest, "invalid_grant", "the user no longer exists")
+                return
+            }
+            token, refreshToken, err := helper.CreateClientSession(c, foundUser, client, code.Scope, code.Session_id)
+            if err != nil {
+                log.Println("Error creating client session:", err)
+                tokenError(c, http.StatusInternalServerError, "server_error", "")
+                return
+            }
+            idToken, err := helper.GenerateIDToken(foundUser, client.Client_id, code.Scope, code.Nonce, code.Auth_time)
+            if err != nil {
+                log.Println("Error generating ID token:", err)
+                tokenError(c, http.StatusInternalServerError, "server_error", "")
+                return
+            }
+
+            response := gin.H{
+                "access_token": token,
+                "token_type":   "Bearer",
+                "expires_in":   int(helper.AccessTokenLifetime.Seconds()),
+                "scope":        code.Scope,
+                "id_token":     idToken,
+            }
+            // without offline_access the client only has the user while the access token lasts
+            if helper.ScopeIncludes(code.Scope, "offline_access") {
+                response["refresh_token"] = refreshToken
+            }
+            c.JSON(http.StatusOK, response)
+
+        case "refresh_token":
+            presented := c.PostForm("refresh_token")
+            claims, msg := helper.ValidateRefreshToken(presented)
+            if msg != "" || claims.Client_id != client.Client_id {
+                tokenError(c, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid")
+                return
+            }
+
+            var foundUser models.User
+            if err := userDB.WithContext(ctx).Where("user_id = ?", claims.Uid).First(&foundUser).Error; err != nil {
+                tokenError(c, http.StatusBadRequest, "invalid_grant", "the refresh token is invalid")
+                return
+            }
+            token, refreshToken, err := helper.RotateSession(presented, claims, foundUser)
+            if err == helper.ErrRefreshTokenReused || err == helper.ErrSessionRevoked {
+                if err == helper.ErrRefreshTokenReused {
+                    log.Printf("refresh token reuse detected for client %s, session %s revoked", client.Client_id, claims.Family_id)
+                }
+                tokenError(c, http.StatusBadRequest, "invalid_grant", "the refresh token has been revoked")
+                return
+            }
+            if err != nil {
+                log.Println("Error rotating session:", err)
+                tokenError(c, http.StatusInternalServerError, "server_error", "")
+                return
+            }
+
+            c.JSON(http.StatusOK, gin.H{
+                "access_token":  token,
+                "refresh_token": refreshToken,
+                "token_type":    "Bearer",
+                "expires_in":    int(helper.AccessTokenLifetime.Seconds()),
+                "scope":         claims.Scope,
+            })
+
+        case "client_credentials":
+            // a machine client asks for some of its scopes, or gets all of them
+            scope := strings.Join(helper.ParseScope(c.PostForm("scope")), " ")
+            if scope == "" {
+                scope = client.Scopes
+            }
+            if !helper.ScopeCovers(client.Scopes, scope) {
+                tokenError(c, http.StatusBadRequest, "invalid_scope", "the client may not ask for this scope")
+                return
+            }
+
+            token, err := helper.GenerateClientToken(client, scope)
+            if err != nil {
+                log.Println("Error generating client token:", err)
+                tokenError(c, http.StatusInternalServerError, "server_error", "")
+                return
+            }
+            c.JSON(http.StatusOK, gin.H{
+                "access_token": token,
+                "token_type":   "Bearer",
+                "expires_in":   int(helper.ClientTokenLifetime.Seconds()),
+                "scope":        scope,
+            })
+
+        default:
+            tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+        }
+    }
+}
+
+// tokenError answers the token endpoint with an OAuth error.
+func tokenError(c *gin.Context, status int, code string, description string) {
+    body := gin.H{"error": code}
+    if description != "" {
+        body["error_description"] = description
+    }
+    c.JSON(status, body)
+}
+
+// UserInfo returns the claims of the user the access token was issued for, as far
+// as the scope of the token allows.
+func UserInfo() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        parts := strings.Split(c.GetHeader("Authorization"), " ")
+        if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+            c.Header("WWW-Authenticate", `Bearer realm="userinfo"`)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_request"})
+            return
+        }
+
+        // only tokens issued to a client through Authorize, with the openid scope
+        claims, msg := helper.ValidateToken(parts[1])
+        if msg != "" || claims.Client_id == "" || claims.Principal_type == helper.ClientPrincipal || !helper.ScopeIncludes(claims.Scope, "openid") {
+            c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", claims.Uid).First(&foundUser).Error; err != nil {
+            c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+            return
+        }
+
+        c.JSON(http.StatusOK, helper.UserInfo(foundUser, claims.Scope))
+    }
+}
+
+// RegisterOAuthClient lets an admin add an app that logs its users in through us.
+// The secret of a confidential client is only in this response.
+func RegisterOAuthClient() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var req registerClientRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var scopes, grantTypes []string
+        redirectURIs := []string{}
+        if req.Machine {
+            // a machine client has nobody to redirect and nobody to ask, only its secret
+            if req.Public || len(req.Redirect_uris) > 0 || req.Skip_consent {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "a machine client is confidential and has no redirect URIs or consent"})
+                return
+            }
+            if len(req.Scopes) == 0 || !helper.ValidScopes(req.Scopes, helper.ServiceScopes) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "a machine client needs scopes out of " + strings.Join(helper.ServiceScopes, ", ")})
+                return
+            }
+            scopes = helper.ParseScope(strings.Join(req.Scopes, " "))
+            grantTypes = []string{"client_credentials"}
+        } else {
+            for _, uri := range req.Redirect_uris {
+                if strings.Contains(uri, "#") {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "redirect URIs can't have a fragment"})
+                    return
+                }
+            }
+            if !helper.ValidScopes(req.Scopes, helper.SupportedOIDCScopes) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "scopes must be out of " + strings.Join(helper.SupportedOIDCScopes, ", ")})
+                return
+            }
+            scopes = helper.ParseScope("openid " + strings.Join(req.Scopes, " "))
+            if len(req.Scopes) == 0 {
+                scopes = []string{"openid", "profile", "email"}
+            }
+            redirectURIs = req.Redirect_uris
+            grantTypes = []string{"authorization_code", "refresh_token"}
+        }
+
+        client, secret, err := helper.RegisterOAuthClient(req.Name, redirectURIs, scopes, grantTypes, !req.Public, req.Skip_consent)
+        if err != nil {
+            log.Println("Error registering OAuth client:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register the client"})
+            return
+        }
+
+        response := gin.H{"client": client}
+        if secret != "" {
+            response["client_secret"] = secret
+        }
+        c.JSON(http.StatusCreated, response)
+    }
+}
+
+// GetOAuthClients lists the registered clients, admins only.
+func GetOAuthClients() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        clients, err := helper.ListOAuthClients()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list clients"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"clients": clients})
+    }
+}
+
+// DeleteOAuthClient removes a client, the sessions it was granted end with it.
+func DeleteOAuthClient() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        err := helper.DeleteOAuthClient(c.Param("client_id"))
+        if errors.Is(err, helper.ErrInvalidClient) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+            return
+        }
+        if err != nil {
+            log.Println("Error deleting OAuth client:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete the client"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"success": "client deleted"})
+    }
+}
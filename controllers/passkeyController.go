@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// finishPasskeyRequest is the body of both finish endpoints. Credential is the
+// PublicKeyCredential from navigator.credentials.create() / get(), as JSON.
+type finishPasskeyRequest struct {
+    Challenge_id string          `json:"challenge_id" validate:"required"`
+    Name         string          `json:"name" validate:"max=100"`
+    Credential   json.RawMessage `json:"credential" validate:"required"`
+}
+
+// beginPasskeyRegistrationRequest proves it is really the user at the keyboard, a
+// passkey logs in on its own and must not be addable with a stolen access token.
+// Password is the current password, Code a TOTP code (with MFA enabled).
+type beginPasskeyRegistrationRequest struct {
+    Password string `json:"password" validate:"required_without=Code"`
+    Code     string `json:"code" validate:"required_without=Password"`
+}
+
+// BeginPasskeyRegistration starts adding a passkey to the logged in user, once they
+// entered their password or a TOTP code again. It answers with the options for
+// navigator.credentials.create() and the challenge_id to finish with.
+func BeginPasskeyRegistration() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req beginPasskeyRegistrationRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if !reauthenticate(c, foundUser, req.Password, req.Code) {
+            return
+        }
+        passkeyUser, err := helper.LoadPasskeyUser(foundUser)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+            return
+        }
+
+        exclusions := make([]protocol.CredentialDescriptor, 0, len(passkeyUser.WebAuthnCredentials()))
+        for _, credential := range passkeyUser.WebAuthnCredentials() {
+            exclusions = append(exclusions, credential.Descriptor())
+        }
+        // a passkey has to be discoverable, the login doesn't ask for an email first
+        options, session, err := helper.WebAuthn.BeginRegistration(passkeyUser,
+            webauthn.WithExclusions(exclusions),
+            webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+        )
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+            return
+        }
+        challengeID, err := helper.SavePasskeyChallenge(foundUser.User_id, helper.PasskeyRegistration, session)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "challenge_id": challengeID,
+            "options":      options,
+        })
+    }
+}
+
+// reauthenticate checks the password or TOTP code a logged in user entered again
+// and answers the request itself when it is wrong. Wrong ones count towards the
+// login lockout, the access token alone doesn't give unlimited guesses.
+func reauthenticate(c *gin.Context, user models.User, password string, code string) bool {
+    if wait := helper.LoginRetryAfter(*user.Email); wait > 0 {
+        c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+        c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+        return false
+    }
+
+    if code != "" {
+        if !user.Mfa_enabled {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled, enter your password"})
+            return false
+        }
+        if !helper.VerifyUserTOTP(user, code) {
+            recordLoginFailure(*user.Email)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the code is incorrect"})
+            return false
+        }
+        return true
+    }
+
+    if user.Password == nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "the account has no password yet, set one with the password reset"})
+        return false
+    }
+    isPasswordValid, err := VerifyPassword(password, *user.Password)
+    if err != nil {
+        respondHashError(c, err)
+        return false
+    }
+    if !isPasswordValid {
+        recordLoginFailure(*user.Email)
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "the password is incorrect"})
+        return false
+    }
+    return true
+}
+
+// FinishPasskeyRegistration verifies the attestation and stores the new passkey.
+func FinishPasskeyRegistration() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req finishPasskeyRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        userId := c.GetString("uid")
+        session, err := helper.TakePasskeyChallenge(req.Challenge_id, userId, helper.PasskeyRegistration)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", userId).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        passkeyUser, err := helper.LoadPasskeyUser(foundUser)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load passkeys"})
+            return
+        }
+
+        parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "the credential is invalid"})
+            return
+        }
+        credential, err := helper.WebAuthn.CreateCredential(passkeyUser, *session, parsed)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "the credential is invalid"})
+            return
+        }
+
+        name := req.Name
+        if name == "" {
+            name = "Passkey"
+        }
+        saved, err := helper.SavePasskeyCredential(userId, name, credential)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save passkey"})
+            return
+        }
+        c.JSON(http.StatusOK, saved)
+    }
+}
+
+// GetPasskeys lists the passkeys of the logged in user.
+func GetPasskeys() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var passkeys []models.PasskeyCredential
+        err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).
+            Order("created_at").Find(&passkeys).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing passkeys"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"passkeys": passkeys})
+    }
+}
+
+// deletePasskeyRequest asks for the password or a TOTP code again, like
+// beginPasskeyRegistrationRequest.
+type deletePasskeyRequest struct {
+    Password string `json:"password" validate:"required_without=Code"`
+    Code     string `json:"code" validate:"required_without=Password"`
+}
+
+// DeletePasskey removes one passkey of the logged in user, once they entered their
+// password or a TOTP code again.
+func DeletePasskey() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req deletePasskeyRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.GetString("uid")).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if !reauthenticate(c, foundUser, req.Password, req.Code) {
+            return
+        }
+
+        err := helper.DeletePasskeyCredential(foundUser, c.Param("credential_id"))
+        switch {
+        case errors.Is(err, helper.ErrPasskeyNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        case errors.Is(err, helper.ErrLastLoginMethod):
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        case err != nil:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete passkey"})
+        default:
+            c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+        }
+    }
+}
+
+// BeginPasskeyLogin starts a passkey login. Nobody is identified yet, the browser
+// lets the user pick one of their passkeys for this site.
+func BeginPasskeyLogin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        options, session, err := helper.WebAuthn.BeginDiscoverableLogin(
+            webauthn.WithUserVerification(protocol.VerificationRequired),
+        )
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+            return
+        }
+        challengeID, err := helper.SavePasskeyChallenge("", helper.PasskeyLogin, session)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "challenge_id": challengeID,
+            "options":      options,
+        })
+    }
+}
+
+// FinishPasskeyLogin verifies the assertion and starts a session. A passkey with
+// user verification already is two factors, so there is no TOTP step after it.
+func FinishPasskeyLogin() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req finishPasskeyRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        session, err := helper.TakePasskeyChallenge(req.Challenge_id, "", helper.PasskeyLogin)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey login failed"})
+            return
+        }
+
+        // the user handle is the User_id we registered the passkey with
+        findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+            var foundUser models.User
+            if err := userDB.WithContext(ctx).Where("user_id = ?", string(userHandle)).First(&foundUser).Error; err != nil {
+                return nil, err
+            }
+            return helper.LoadPasskeyUser(foundUser)
+        }
+        user, credential, err := helper.WebAuthn.ValidatePasskeyLogin(findUser, *session, parsed)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey login failed"})
+            return
+        }
+        passkeyUser := user.(*helper.PasskeyUser)
+
+        // the sign counter went backwards, somebody may have a copy of the key
+        if credential.Authenticator.CloneWarning {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey login failed"})
+            return
+        }
+        if err := helper.UpdatePasskeyCredential(passkeyUser.User.User_id, credential); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update passkey"})
+            return
+        }
+
+        issueSession(c, passkeyUser.User)
+    }
+}
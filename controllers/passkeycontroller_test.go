@@ -0,0 +1,208 @@
+package controllers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+var b64 = base64.RawURLEncoding
+
+// softAuthenticator is a passkey in software: one P-256 key, "none" attestation,
+// user presence and verification always given.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{key: key, credentialID: credentialID}
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, options map[string]interface{}) []byte {
+	t.Helper()
+	publicKey := options["publicKey"].(map[string]interface{})
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": publicKey["challenge"].(string),
+		"origin":    helper.FrontendURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// authData is rpIdHash, flags and the sign counter, plus attested for a new credential.
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(helper.WebAuthn.Config.RPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// create answers the options of BeginPasskeyRegistration like navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	user := options["publicKey"].(map[string]interface{})["user"].(map[string]interface{})
+	userHandle, err := b64.DecodeString(user["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // all zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested), // UP, UV and AT
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(a.clientData(t, "webauthn.create", options)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	}
+}
+
+// get answers the options of BeginPasskeyLogin like navigator.credentials.get().
+func (a *softAuthenticator) get(t *testing.T, options map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	a.signCount++
+	clientData := a.clientData(t, "webauthn.get", options)
+	authData := a.authData(0x05, nil) // UP and UV
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	}
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	router, _ := newRouter(t)
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	token := user["token"].(string)
+	authenticator := newSoftAuthenticator(t)
+
+	// the access token alone is not enough
+	status, body := call(t, router, http.MethodPost, "/users/me/passkeys/register/begin", token, gin.H{})
+	if status != http.StatusBadRequest {
+		t.Fatalf("begin without the password: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodPost, "/users/me/passkeys/register/begin", token, gin.H{"password": "not-the-password-at-all"})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("begin with a wrong password: %d %v", status, body)
+	}
+	helper.ResetLoginFailures("user@example.com")
+
+	status, begin := call(t, router, http.MethodPost, "/users/me/passkeys/register/begin", token, gin.H{"password": testPassword})
+	if status != http.StatusOK {
+		t.Fatalf("begin registration: %d %v", status, begin)
+	}
+	status, body = call(t, router, http.MethodPost, "/users/me/passkeys/register/finish", token, gin.H{
+		"challenge_id": begin["challenge_id"],
+		"name":         "Test key",
+		"credential":   authenticator.create(t, begin["options"].(map[string]interface{})),
+	})
+	if status != http.StatusOK {
+		t.Fatalf("finish registration: %d %v", status, body)
+	}
+
+	status, begin = call(t, router, http.MethodPost, "/users/login/passkey/begin", "", nil)
+	if status != http.StatusOK {
+		t.Fatalf("begin login: %d %v", status, begin)
+	}
+	status, body = call(t, router, http.MethodPost, "/users/login/passkey/finish", "", gin.H{
+		"challenge_id": begin["challenge_id"],
+		"credential":   authenticator.get(t, begin["options"].(map[string]interface{})),
+	})
+	if status != http.StatusOK || body["token"] == nil {
+		t.Fatalf("finish login: %d %v", status, body)
+	}
+
+	// the same assertion again, the challenge is used up
+	status, body = call(t, router, http.MethodPost, "/users/login/passkey/finish", "", gin.H{
+		"challenge_id": begin["challenge_id"],
+		"credential":   authenticator.get(t, begin["options"].(map[string]interface{})),
+	})
+	if status == http.StatusOK {
+		t.Fatalf("a challenge was accepted twice: %d %v", status, body)
+	}
+
+	// a different key under the same credential id
+	impostor := newSoftAuthenticator(t)
+	impostor.credentialID, impostor.userHandle, impostor.signCount = authenticator.credentialID, authenticator.userHandle, 10
+	_, begin = call(t, router, http.MethodPost, "/users/login/passkey/begin", "", nil)
+	status, body = call(t, router, http.MethodPost, "/users/login/passkey/finish", "", gin.H{
+		"challenge_id": begin["challenge_id"],
+		"credential":   impostor.get(t, begin["options"].(map[string]interface{})),
+	})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("a signature of another key was accepted: %d %v", status, body)
+	}
+
+	// removing it needs the password again too
+	path := "/users/me/passkeys/" + b64.EncodeToString(authenticator.credentialID)
+	status, body = call(t, router, http.MethodDelete, path, token, gin.H{})
+	if status != http.StatusBadRequest {
+		t.Fatalf("delete without the password: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodDelete, path, token, gin.H{"password": testPassword})
+	if status != http.StatusOK {
+		t.Fatalf("delete: %d %v", status, body)
+	}
+}
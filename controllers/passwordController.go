@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/mailer"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+var passwordResetTTL = helper.EnvDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+
+type forgotPasswordRequest struct {
+    Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordRequest struct {
+    Token    string `json:"token" validate:"required"`
+    Password string `json:"password" validate:"required"`
+}
+
+// checkPasswordPolicy answers 400 with the broken rules listed under field when the
+// password doesn't satisfy the policy, and reports whether it does.
+func checkPasswordPolicy(c *gin.Context, field string, password string, user models.User) bool {
+    var personal []string
+    for _, value := range []*string{user.Email, user.First_name, user.Last_name} {
+        if value != nil {
+            personal = append(personal, *value)
+        }
+    }
+    problems := helper.CurrentPasswordPolicy.CheckPassword(password, personal...)
+    if len(problems) == 0 {
+        return true
+    }
+    c.JSON(http.StatusBadRequest, gin.H{
+        "error":  "the password does not meet the requirements",
+        "fields": gin.H{field: problems},
+    })
+    return false
+}
+
+// ForgotPassword emails a short-lived single-use reset link. The answer is always
+// 202, whether the email has an account or not, so it can't be used to probe for users.
+func ForgotPassword() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req forgotPasswordRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var foundUser models.User
+        err := userDB.WithContext(ctx).Where("email = ?", req.Email).First(&foundUser).Error
+        // one email a minute at most, so the endpoint can't be used to flood an inbox
+        if err == nil && time.Since(helper.LastActionTokenIssuedAt(foundUser.User_id, helper.PasswordResetTokenType)) > time.Minute {
+            if err := sendPasswordResetEmail(foundUser); err != nil {
+                log.Println("Error sending password reset email:", err)
+            }
+        }
+
+        c.JSON(http.StatusAccepted, gin.H{"success": "if the account exists, a password reset email has been sent"})
+    }
+}
+
+func sendPasswordResetEmail(user models.User) error {
+    token, err := helper.IssueActionToken(user, helper.PasswordResetTokenType, passwordResetTTL)
+    if err != nil {
+        return err
+    }
+    link := helper.FrontendURL() + "/reset-password?token=" + url.QueryEscape(token)
+
+    return mailer.SendTemplate(*user.Email, "reset_password", map[string]interface{}{
+        "Name":     *user.First_name,
+        "Link":     link,
+        "ValidFor": passwordResetTTL.String(),
+    })
+}
+
+// ResetPassword sets a new password with the token from the reset email. Every
+// session and token of the user is revoked, whoever had access before is locked out.
+func ResetPassword() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req resetPasswordRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        claims, msg := helper.ConsumeActionToken(req.Token, helper.PasswordResetTokenType)
+        if msg != "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+            return
+        }
+
+        var foundUser models.User
+        err := userDB.WithContext(ctx).Where("user_id = ? AND email = ?", claims.Uid, claims.Email).First(&foundUser).Error
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "the token is invalid"})
+            return
+        }
+        if !checkPasswordPolicy(c, "password", req.Password, foundUser) {
+            return
+        }
+
+        password, err := HashPassword(req.Password)
+        if err != nil {
+            respondHashError(c, err)
+            return
+        }
+        err = userDB.WithContext(ctx).Model(&models.User{}).
+            Where("user_id = ?", foundUser.User_id).
+            Updates(map[string]interface{}{
+                "password":   password,
+                "updated_at": time.Now(),
+            }).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+            return
+        }
+
+        if err := helper.RevokeAllUserTokens(foundUser.User_id); err != nil {
+            log.Println("Error revoking user tokens:", err)
+        }
+        if err := helper.RevokeAllSessions(foundUser.User_id); err != nil {
+            log.Println("Error revoking sessions:", err)
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "password has been reset, please log in again"})
+    }
+}
+
+type changePasswordRequest struct {
+    Current_password string `json:"current_password" validate:"required"`
+    New_password     string `json:"new_password" validate:"required"`
+}
+
+// ChangePassword lets a logged in user pick a new password. The current password is
+// required, and every other session is ended while the one making the call stays.
+func ChangePassword() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req changePasswordRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        uid := c.GetString("uid")
+        var foundUser models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", uid).First(&foundUser).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+
+        // a wrong current password counts towards the login lockout
+        if !reauthenticate(c, foundUser, req.Current_password, "") {
+            return
+        }
+        if !checkPasswordPolicy(c, "new_password", req.New_password, foundUser) {
+            return
+        }
+
+        password, err := HashPassword(req.New_password)
+        if err != nil {
+            respondHashError(c, err)
+            return
+        }
+        err = userDB.WithContext(ctx).Model(&models.User{}).
+            Where("user_id = ?", uid).
+            Updates(map[string]interface{}{
+                "password":   password,
+                "updated_at": time.Now(),
+            }).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+            return
+        }
+
+        if err := helper.RevokeOtherSessions(uid, c.GetString("session_id")); err != nil {
+            log.Println("Error revoking other sessions:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "password changed, but other sessions could not be ended"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "password changed, other sessions have been logged out"})
+    }
+}
@@ -0,0 +1,29 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWrongCurrentPasswordCountsTowardsTheLockout(t *testing.T) {
+	router, _ := newRouter(t)
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	token := user["token"].(string)
+
+	status, body := call(t, router, http.MethodPut, "/users/me/password", token, gin.H{"current_password": "not-the-password-at-all", "new_password": "a-brand-new-long-passphrase-7"})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("wrong current password: %d %v", status, body)
+	}
+
+	// even the right one has to wait for the backoff now
+	status, body = call(t, router, http.MethodPut, "/users/me/password", token, gin.H{"current_password": testPassword, "new_password": "a-brand-new-long-passphrase-7"})
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("retry right after a wrong password: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodPost, "/user/login", "", gin.H{"email": "user@example.com", "password": testPassword})
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("login right after a wrong password: %d %v", status, body)
+	}
+}
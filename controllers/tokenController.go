@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	models "github.com/Aaryansingh20/jwt/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type refreshRequest struct {
+    Refresh_token string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh pair.
+// The refresh token is rotated on every use, and presenting an already used one
+// revokes the whole session since that means the token has leaked.
+func RefreshToken() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var req refreshRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := validate.Struct(req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        claims, msg := helper.ValidateRefreshToken(req.Refresh_token)
+        if msg != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+            return
+        }
+        // the refresh tokens of OAuth clients are exchanged at their token endpoint
+        if claims.Client_id != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the refresh token is invalid"})
+            return
+        }
+
+        var foundUser models.User
+        err := userDB.WithContext(ctx).Where("user_id = ?", claims.Uid).First(&foundUser).Error
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the refresh token is invalid"})
+            return
+        }
+
+        token, refreshToken, err := helper.RotateSession(req.Refresh_token, claims, foundUser)
+        if err == helper.ErrRefreshTokenReused {
+            // the token belongs to the session but is not its current token, so somebody
+            // already used it. RotateSession has killed the session for everyone.
+            log.Printf("refresh token reuse detected for user %s, session %s revoked", foundUser.User_id, claims.Family_id)
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the refresh token has been revoked"})
+            return
+        }
+        if err == helper.ErrSessionRevoked {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+            return
+        }
+        if err != nil {
+            log.Println("Error rotating session:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate tokens"})
+            return
+        }
+
+        c.JSON(http.StatusOK, models.NewTokenResponse(token, refreshToken, foundUser))
+    }
+}
+
+type revokeRequest struct {
+    Token         string     `json:"token"`
+    Jti           string     `json:"jti"`
+    User_id       string     `json:"user_id"`
+    Issued_before *time.Time `json:"issued_before"`
+}
+
+// RevokeTokens lets an admin revoke a single token (by the token itself or its jti),
+// every token of a user, or every token issued before a timestamp.
+func RevokeTokens() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var req revokeRequest
+        if err := c.BindJSON(&req); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var err error
+        switch {
+        case req.Token != "":
+            claims, msg := helper.ValidateToken(req.Token)
+            if msg != "" {
+                claims, msg = helper.ValidateRefreshToken(req.Token)
+            }
+            if msg != "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+                return
+            }
+            if claims.Id == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "the token has no jti, revoke the user's tokens instead"})
+                return
+            }
+            err = helper.RevokeToken(claims.Id, claims.Uid, time.Unix(claims.ExpiresAt, 0))
+        case req.Jti != "":
+            // we don't know when this token expires, so keep it as long as any token can live
+            err = helper.RevokeToken(req.Jti, req.User_id, time.Now().Add(helper.MaxTokenLifetime))
+        case req.User_id != "":
+            err = helper.RevokeAllUserTokens(req.User_id)
+        case req.Issued_before != nil:
+            err = helper.RevokeTokensIssuedBefore(*req.Issued_before)
+        default:
+            c.JSON(http.StatusBadRequest, gin.H{"error": "one of token, jti, user_id or issued_before is required"})
+            return
+        }
+        if err != nil {
+            log.Println("Error revoking tokens:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "tokens revoked"})
+    }
+}
+
+// Logout ends the session the access token belongs to: the token itself and the
+// session's refresh token can't be used anymore.
+func Logout() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        uid := c.GetString("uid")
+
+        if jti := c.GetString("jti"); jti != "" {
+            if err := helper.RevokeToken(jti, uid, time.Unix(c.GetInt64("expires_at"), 0)); err != nil {
+                log.Println("Error revoking access token:", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+                return
+            }
+        }
+        if sessionID := c.GetString("session_id"); sessionID != "" {
+            if err := helper.RevokeSession(uid, sessionID); err != nil {
+                log.Println("Error revoking session:", err)
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+                return
+            }
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "logged out"})
+    }
+}
+
+// LogoutAll invalidates every outstanding token of the user, on every device.
+func LogoutAll() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        uid := c.GetString("uid")
+
+        if err := helper.RevokeAllUserTokens(uid); err != nil {
+            log.Println("Error revoking user tokens:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+            return
+        }
+        if err := helper.RevokeAllSessions(uid); err != nil {
+            log.Println("Error revoking sessions:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "logged out everywhere"})
+    }
+}
+
+// GetSessions lists the active sessions (devices) of the logged in user.
+func GetSessions() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        sessions, err := helper.ListSessions(c.GetString("uid"))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing sessions"})
+            return
+        }
+
+        current := c.GetString("session_id")
+        items := make([]gin.H, 0, len(sessions))
+        for _, session := range sessions {
+            items = append(items, gin.H{
+                "session_id":   session.Session_id,
+                "device_name":  session.Device_name,
+                "user_agent":   session.User_agent,
+                "ip":           session.Ip,
+                "created_at":   session.Created_at,
+                "last_used_at": session.Last_used_at,
+                "current":      session.Session_id == current,
+            })
+        }
+
+        c.JSON(http.StatusOK, gin.H{"sessions": items})
+    }
+}
+
+// RevokeSession ends one of the logged in user's own sessions.
+func RevokeSession() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        // RevokeUserSession is scoped to the uid, so nobody can end somebody else's session
+        err := helper.RevokeUserSession(c.GetString("uid"), c.Param("session_id"))
+        if errors.Is(err, helper.ErrSessionNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        if err != nil {
+            log.Println("Error revoking session:", err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{"success": "session revoked"})
+    }
+}
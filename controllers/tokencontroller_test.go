@@ -0,0 +1,115 @@
+package controllers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRevokeSessionOnlyEndsOwnSessions(t *testing.T) {
+	router, _ := newRouter(t)
+	ada := signUp(t, router, "ada@example.com", "5550001", "USER")
+	bob := signUp(t, router, "bob@example.com", "5550002", "USER")
+
+	status, body := call(t, router, http.MethodGet, "/users/me/sessions", bob["token"].(string), nil)
+	if status != http.StatusOK {
+		t.Fatalf("list sessions: %d %v", status, body)
+	}
+	bobSession := body["sessions"].([]interface{})[0].(map[string]interface{})["session_id"].(string)
+
+	status, body = call(t, router, http.MethodDelete, "/users/me/sessions/"+bobSession, ada["token"].(string), nil)
+	if status != http.StatusNotFound {
+		t.Fatalf("ending somebody else's session: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodDelete, "/users/me/sessions/no-such-session", ada["token"].(string), nil)
+	if status != http.StatusNotFound {
+		t.Fatalf("ending an unknown session: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodGet, "/users/me/sessions", bob["token"].(string), nil)
+	if status != http.StatusOK {
+		t.Fatalf("bob's session was ended: %d %v", status, body)
+	}
+
+	status, body = call(t, router, http.MethodDelete, "/users/me/sessions/"+bobSession, bob["token"].(string), nil)
+	if status != http.StatusOK {
+		t.Fatalf("ending the own session: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodDelete, "/users/me/sessions/"+bobSession, bob["token"].(string), nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the token of the ended session still works: %d %v", status, body)
+	}
+}
+
+func TestReusedRefreshTokenEndsTheSession(t *testing.T) {
+	router, _ := newRouter(t)
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+
+	status, first := call(t, router, http.MethodPost, "/users/refresh", "", gin.H{"refresh_token": user["refresh_token"]})
+	if status != http.StatusOK {
+		t.Fatalf("refresh: %d %v", status, first)
+	}
+
+	// somebody else replays the old refresh token
+	status, body := call(t, router, http.MethodPost, "/users/refresh", "", gin.H{"refresh_token": user["refresh_token"]})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("a used refresh token was exchanged again: %d %v", status, body)
+	}
+
+	// which ends the session for the rightful owner as well
+	status, body = call(t, router, http.MethodPost, "/users/refresh", "", gin.H{"refresh_token": first["refresh_token"]})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the newest refresh token of a reused session still works: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodGet, "/users/me/sessions", first["token"].(string), nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the access token of a reused session still works: %d %v", status, body)
+	}
+}
+
+func TestRevokedTokensAreRefused(t *testing.T) {
+	router, _ := newRouter(t)
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	login := func() string {
+		t.Helper()
+		status, body := call(t, router, http.MethodPost, "/user/login", "", gin.H{"email": "user@example.com", "password": testPassword})
+		if status != http.StatusOK {
+			t.Fatalf("login: %d %v", status, body)
+		}
+		return body["token"].(string)
+	}
+
+	// a logout puts the jti of the access token on the denylist
+	token := login()
+	status, body := call(t, router, http.MethodPost, "/users/logout", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("logout: %d %v", status, body)
+	}
+	status, body = call(t, router, http.MethodGet, "/users/me/sessions", token, nil)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("the token still works after logout: %d %v", status, body)
+	}
+
+	// logout-all cuts off everything issued before it, from every device
+	token = login()
+	status, body = call(t, router, http.MethodPost, "/users/logout-all", token, nil)
+	if status != http.StatusOK {
+		t.Fatalf("logout-all: %d %v", status, body)
+	}
+	for _, old := range []string{token, user["token"].(string)} {
+		status, body = call(t, router, http.MethodGet, "/users/me/sessions", old, nil)
+		if status != http.StatusUnauthorized {
+			t.Fatalf("a token from before logout-all still works: %d %v", status, body)
+		}
+	}
+	status, body = call(t, router, http.MethodPost, "/users/refresh", "", gin.H{"refresh_token": user["refresh_token"]})
+	if status != http.StatusUnauthorized {
+		t.Fatalf("a refresh token from before logout-all still works: %d %v", status, body)
+	}
+
+	// a login afterwards is not affected
+	status, body = call(t, router, http.MethodGet, "/users/me/sessions", login(), nil)
+	if status != http.StatusOK {
+		t.Fatalf("a token from after logout-all is refused: %d %v", status, body)
+	}
+}
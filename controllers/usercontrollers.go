@@ -2,8 +2,10 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,30 +16,49 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 var userDB *gorm.DB = database.Client
 var validate = validator.New()
 
-func HashPassword(password string) string {
-    hashed, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-    if err != nil {
-        log.Panic(err)
-    }
-    return string(hashed)
+// HashPassword hashes with the configured algorithm, see helpers.HashPassword. It fails
+// with helpers.ErrHashPoolBusy when too many hashes are running, see respondHashError.
+func HashPassword(password string) (string, error) {
+    return helper.HashPassword(password)
 }
 
-func VerifyPassword(userPassword, providedPassword string) (bool, string) {
-    err := bcrypt.CompareHashAndPassword([]byte(providedPassword), []byte(userPassword))
-    check := true
-    msg := ""
-    if err != nil {
-        check = false
-        msg = fmt.Sprintf("email or password is incorrect.")
+// VerifyPassword checks the password the user gave against the stored hash.
+func VerifyPassword(userPassword, providedPassword string) (bool, error) {
+    return helper.VerifyPassword(userPassword, providedPassword)
+}
+
+// respondHashError answers for a failed HashPassword or VerifyPassword. A full
+// hashing pool is a 503 with Retry-After, the client should simply try again.
+func respondHashError(c *gin.Context, err error) {
+    if errors.Is(err, helper.ErrHashPoolBusy) {
+        retryAfter := int(math.Ceil(helper.HashQueueTimeout.Seconds()))
+        c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+        c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+        return
     }
-    return check, msg
+    c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+}
+
+// signUpRequest is the body of SignUp. It is kept apart from models.User so a client
+// can never set fields like the user_id, and the user row never needs a json password.
+type signUpRequest struct {
+    First_name *string `json:"first_name" validate:"required,min=2,max=100"`
+    Last_name  *string `json:"last_name" validate:"required,min=2,max=100"`
+    Password   *string `json:"password" validate:"required"` // the rest is up to the password policy
+    Email      *string `json:"email" validate:"email,required"` //validate email means it should have an @
+    Phone      *string `json:"phone" validate:"required"`
+    User_type  *string `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
+}
+
+type loginRequest struct {
+    Email    *string `json:"email" validate:"required"`
+    Password *string `json:"password" validate:"required"`
 }
 
 func SignUp() gin.HandlerFunc {
@@ -45,20 +66,32 @@ func SignUp() gin.HandlerFunc {
         var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
         defer cancel()
         
-        var user models.User
+        var req signUpRequest
 
-        if err := c.BindJSON(&user); err != nil {
+        if err := c.BindJSON(&req); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
 
-        validationErr := validate.Struct(user)
-        // this is used to validate, but what? see the User struct, and see those validate struct fields
+        validationErr := validate.Struct(req)
+        // this is used to validate, but what? see the signUpRequest struct, and see those validate struct fields
         if validationErr != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
             return
         }
 
+        user := models.User{
+            First_name: req.First_name,
+            Last_name:  req.Last_name,
+            Password:   req.Password,
+            Email:      req.Email,
+            Phone:      req.Phone,
+            User_type:  req.User_type,
+        }
+        if !checkPasswordPolicy(c, "password", *req.Password, user) {
+            return
+        }
+
         // Check if email already exists (PostgreSQL version)
         var count int64
         userDB.WithContext(ctx).Model(&models.User{}).Where("email = ?", user.Email).Count(&count)
@@ -67,7 +100,11 @@ func SignUp() gin.HandlerFunc {
             return
         }
 
-        password := HashPassword(*user.Password)
+        password, err := HashPassword(*user.Password)
+        if err != nil {
+            respondHashError(c, err)
+            return
+        }
         user.Password = &password
 
         // Check if phone already exists (PostgreSQL version)
@@ -81,12 +118,6 @@ func SignUp() gin.HandlerFunc {
         user.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
         user.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
         user.User_id = fmt.Sprintf("%d", time.Now().UnixNano()) // Generate unique ID
-        
-        token, refreshToken, _ := helper.GenerateAllTokens(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id)
-
-        // giving value that we generated to user
-        user.Token = &token
-        user.Refresh_token = &refreshToken
 
         // now let's insert it to the database (PostgreSQL version)
         result := userDB.WithContext(ctx).Create(&user)
@@ -96,7 +127,27 @@ func SignUp() gin.HandlerFunc {
             return
         }
 
-        c.JSON(http.StatusOK, user)
+        if err := sendVerificationEmail(user); err != nil {
+            // the user can ask for a new link, no reason to fail the signup
+            log.Println("Error sending verification email:", err)
+        }
+        if requireEmailVerification {
+            // no tokens until the email is verified, Login would refuse them anyway
+            c.JSON(http.StatusOK, gin.H{
+                "user":                  models.NewUserResponse(user),
+                "verification_required": true,
+            })
+            return
+        }
+
+        // the signup device gets the first session of the user
+        token, refreshToken, err := helper.CreateSession(c, user)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+            return
+        }
+
+        c.JSON(http.StatusOK, models.NewTokenResponse(token, refreshToken, user))
     }
 }
 
@@ -105,27 +156,51 @@ func Login() gin.HandlerFunc {
         var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
         defer cancel()
         
-        var user models.User
-        var foundUser models.User
+        var user loginRequest
 
         // giving the user data to user variable
         if err := c.BindJSON(&user); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
+        if err := validate.Struct(user); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        // checked before the lookup and keyed by the email, so unknown emails are
+        // throttled the same way and the answer tells nothing about the account
+        if wait := helper.LoginRetryAfter(*user.Email); wait > 0 {
+            c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+            c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+            return
+        }
 
         // finding the user through email (PostgreSQL version)
-        err := userDB.WithContext(ctx).Where("email = ?", user.Email).First(&foundUser).Error
+        foundUser, err := findUserByEmail(ctx, *user.Email)
         if err != nil {
+            recordLoginFailure(*user.Email)
             c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
             return
         }
 
         // we need pointer to access the original user and foundUser,
         // if we only pass user and foundUser, it will create a new instance of user and foundUser
-        isPasswordValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
+        // social-only accounts have no password, nothing can match
+        if foundUser.Password == nil {
+            recordLoginFailure(*user.Email)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
+            return
+        }
+        isPasswordValid, err := VerifyPassword(*user.Password, *foundUser.Password)
+        if err != nil {
+            // not the user's fault, so it doesn't count as a failed login
+            respondHashError(c, err)
+            return
+        }
         if isPasswordValid != true {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+            recordLoginFailure(*user.Email)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
             return
         }
 
@@ -133,25 +208,94 @@ func Login() gin.HandlerFunc {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "user not found"})
             return
         }
+
+        // with MFA the failures are only forgotten once the code was right too,
+        // see LoginMFA
+        if !foundUser.Mfa_enabled {
+            if err := helper.ResetLoginFailures(*user.Email); err != nil {
+                log.Println("Error resetting failed logins:", err)
+            }
+        }
+        // the plain password is only around right now, so this is where an old
+        // bcrypt hash (or one with outdated parameters) gets replaced
+        if helper.PasswordNeedsRehash(*foundUser.Password) {
+            rehashPassword(ctx, foundUser, *user.Password)
+        }
+
+        // only checked after the password, so it tells nothing about unknown emails
+        if requireEmailVerification && !foundUser.Email_verified {
+            c.JSON(http.StatusForbidden, gin.H{"error": "email address is not verified"})
+            return
+        }
         
-        token, refreshToken, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.First_name, *foundUser.Last_name, *foundUser.User_type, foundUser.User_id)
-        helper.UpdateAllTokens(token, refreshToken, foundUser.User_id)
-        
-        // Get updated user with new tokens (PostgreSQL version)
-        err = userDB.WithContext(ctx).Where("user_id = ?", foundUser.User_id).First(&foundUser).Error
+        completeLogin(c, foundUser)
+    }
+}
+
+// rehashPassword replaces the stored hash, unless the password was changed meanwhile.
+// A failure is only logged, the login itself went fine.
+func rehashPassword(ctx context.Context, user models.User, password string) {
+    hashed, err := helper.HashPassword(password)
+    if err != nil {
+        log.Println("Error rehashing password:", err)
+        return
+    }
+    err = userDB.WithContext(ctx).Model(&models.User{}).
+        Where("user_id = ? AND password = ?", user.User_id, *user.Password).
+        Update("password", hashed).Error
+    if err != nil {
+        log.Println("Error rehashing password:", err)
+    }
+}
+
+func recordLoginFailure(email string) {
+    if err := helper.RecordLoginFailure(email); err != nil {
+        log.Println("Error recording failed login:", err)
+    }
+}
+
+// findUserByEmail is the account lookup every login flow shares, so they all
+// agree on what identifies a user.
+func findUserByEmail(ctx context.Context, email string) (models.User, error) {
+    var foundUser models.User
+    err := userDB.WithContext(ctx).Where("email = ?", email).First(&foundUser).Error
+    return foundUser, err
+}
+
+// completeLogin is the last step of a login once the user proved who they are.
+// Users with MFA get a short-lived mfa_token to exchange at LoginMFA together with
+// a TOTP code, everybody else gets a new session right away.
+func completeLogin(c *gin.Context, user models.User) {
+    if user.Mfa_enabled {
+        mfaToken, err := helper.IssueActionToken(user, helper.MfaPendingTokenType, mfaPendingTTL)
         if err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start MFA"})
             return
         }
-        
-        c.JSON(http.StatusOK, foundUser)
+        c.JSON(http.StatusOK, gin.H{
+            "mfa_required": true,
+            "mfa_token":    mfaToken,
+        })
+        return
+    }
+    issueSession(c, user)
+}
+
+// issueSession answers with a token pair of a new session, every login gets its
+// own session so other devices stay logged in.
+func issueSession(c *gin.Context, user models.User) {
+    token, refreshToken, err := helper.CreateSession(c, user)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+        return
     }
+    c.JSON(http.StatusOK, models.NewTokenResponse(token, refreshToken, user))
 }
 
-// GetUsers can only be accessed by the admin.
+// GetUsers can only be accessed by the admin, or a machine client with users:read.
 func GetUsers() gin.HandlerFunc {
     return func(c *gin.Context) {
-        if err := helper.CheckUserType(c, "ADMIN"); err != nil {
+        if err := helper.CheckScopeOrAdmin(c, "users:read"); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
@@ -187,22 +331,54 @@ func GetUsers() gin.HandlerFunc {
             return
         }
 
+        userItems := make([]models.AdminUserResponse, 0, len(users))
+        for _, user := range users {
+            userItems = append(userItems, models.NewAdminUserResponse(user))
+        }
+
         c.JSON(http.StatusOK, gin.H{
             "total_count": totalCount,
-            "user_items":  users,
+            "user_items":  userItems,
         })
     }
 }
 
+// UnlockUser lifts the failed login lock of an account, admins (or a machine client
+// with users:write) only.
+func UnlockUser() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if err := helper.CheckScopeOrAdmin(c, "users:write"); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+        defer cancel()
+
+        var user models.User
+        if err := userDB.WithContext(ctx).Where("user_id = ?", c.Param("user_id")).First(&user).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+            return
+        }
+        if err := helper.ResetLoginFailures(*user.Email); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"success": "user unlocked"})
+    }
+}
+
 func GetUserById() gin.HandlerFunc {
     return func(c *gin.Context) {
         userId := c.Param("user_id") // we are taking the user_id given by the user in json
         // with the help of gin.context we can access the json data send by postman or curl or user
 
-        if err := helper.MatchUserTypeToUserId(c, userId); err != nil {
-            //checking if the user in admin or not.
-            // we will create that func in helper package.
-
+        // a machine client needs users:read, a user can see themselves and an admin everybody
+        err := helper.MatchUserTypeToUserId(c, userId)
+        if c.GetString("principal_type") == helper.ClientPrincipal {
+            err = helper.CheckScopeOrAdmin(c, "users:read")
+        }
+        if err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
@@ -213,13 +389,18 @@ func GetUserById() gin.HandlerFunc {
         var user models.User
 
         // Find user by user_id (PostgreSQL version)
-        err := userDB.WithContext(ctx).Where("user_id = ?", userId).First(&user).Error
+        err = userDB.WithContext(ctx).Where("user_id = ?", userId).First(&user).Error
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
 
-        // if everything goes ok, pass the data of the user (UserModel.go)
-        c.JSON(http.StatusOK, user)
+        // if everything goes ok, pass the data of the user (userResponse.go).
+        // admins get the bookkeeping fields too
+        if c.GetString("user_type") == "ADMIN" {
+            c.JSON(http.StatusOK, models.NewAdminUserResponse(user))
+            return
+        }
+        c.JSON(http.StatusOK, models.NewUserResponse(user))
     }
 }
@@ -0,0 +1,145 @@
+package controllers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/Aaryansingh20/jwt/routes"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const testPassword = "correct-horse-battery-staple-9"
+
+func newRouter(t *testing.T) (*gin.Engine, *gorm.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := databasetest.Setup(t)
+	router := gin.New()
+	routes.AuthRoutes(router)
+	routes.UserRoutes(router)
+	return router, db
+}
+
+// call sends a JSON request and decodes the JSON answer.
+func call(t *testing.T, router *gin.Engine, method string, path string, token string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: the answer is not JSON: %s", method, path, rec.Body.String())
+	}
+	assertNoPassword(t, method+" "+path, decoded)
+	return rec.Code, decoded
+}
+
+// assertNoPassword fails when any key of the answer, at any depth, is a password.
+func assertNoPassword(t *testing.T, what string, value interface{}) {
+	t.Helper()
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range value {
+			if strings.Contains(strings.ToLower(key), "password") {
+				t.Errorf("%s: the answer has a %q field", what, key)
+			}
+			assertNoPassword(t, what, nested)
+		}
+	case []interface{}:
+		for _, nested := range value {
+			assertNoPassword(t, what, nested)
+		}
+	}
+}
+
+func signUp(t *testing.T, router *gin.Engine, email string, phone string, userType string) map[string]interface{} {
+	t.Helper()
+	status, body := call(t, router, http.MethodPost, "/users/signup", "", gin.H{
+		"first_name": "Ada",
+		"last_name":  "Lovelace",
+		"email":      email,
+		"phone":      phone,
+		"password":   testPassword,
+		"user_type":  userType,
+	})
+	if status != http.StatusOK {
+		t.Fatalf("signup: %d %v", status, body)
+	}
+	return body
+}
+
+func TestResponsesNeverContainThePassword(t *testing.T) {
+	router, db := newRouter(t)
+
+	admin := signUp(t, router, "admin@example.com", "5550001", "ADMIN")
+	user := signUp(t, router, "user@example.com", "5550002", "USER")
+	userID := user["user"].(map[string]interface{})["user_id"].(string)
+
+	// the hash is there, it just never leaves
+	var stored models.User
+	if err := db.Where("user_id = ?", userID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Password == nil || *stored.Password == "" || *stored.Password == testPassword {
+		t.Fatalf("the password is not stored as a hash: %v", stored.Password)
+	}
+
+	status, login := call(t, router, http.MethodPost, "/user/login", "", gin.H{"email": "admin@example.com", "password": testPassword})
+	if status != http.StatusOK {
+		t.Fatalf("login: %d %v", status, login)
+	}
+	adminToken := login["token"].(string)
+
+	status, users := call(t, router, http.MethodGet, "/users", adminToken, nil)
+	if status != http.StatusOK {
+		t.Fatalf("GET /users: %d %v", status, users)
+	}
+	if items := users["user_items"].([]interface{}); len(items) != 2 {
+		t.Fatalf("GET /users: want 2 users, got %d", len(items))
+	}
+
+	status, byID := call(t, router, http.MethodGet, "/users/"+userID, adminToken, nil)
+	if status != http.StatusOK || byID["user_id"] != userID {
+		t.Fatalf("GET /users/:user_id: %d %v", status, byID)
+	}
+
+	status, refreshed := call(t, router, http.MethodPost, "/users/refresh", "", gin.H{"refresh_token": admin["refresh_token"]})
+	if status != http.StatusOK || refreshed["refresh_token"] == admin["refresh_token"] {
+		t.Fatalf("refresh: %d %v", status, refreshed)
+	}
+}
+
+func TestFailedLoginDoesNotEchoThePassword(t *testing.T) {
+	router, _ := newRouter(t)
+	signUp(t, router, "user@example.com", "5550002", "USER")
+
+	status, body := call(t, router, http.MethodPost, "/user/login", "", gin.H{"email": "user@example.com", "password": "not-the-password-at-all"})
+	if status == http.StatusOK {
+		t.Fatalf("login with a wrong password: %d %v", status, body)
+	}
+	if data, _ := json.Marshal(body); strings.Contains(string(data), "not-the-password-at-all") {
+		t.Fatalf("the answer echoes the password: %s", data)
+	}
+}
@@ -32,7 +32,15 @@ func DBinstance() *gorm.DB {
     return client
 }
 
-var Client *gorm.DB = DBinstance()
+// Client is empty until the server connects with Use(DBinstance()), or a test
+// brings its own database (see package databasetest).
+var Client *gorm.DB = &gorm.DB{Config: &gorm.Config{}}
+
+// Use points Client at db. The helpers and controllers keep the *gorm.DB they
+// got from Client at startup, so db is copied into it instead of replacing it.
+func Use(db *gorm.DB) {
+    *Client = *db
+}
 
 // Note: OpenCollection is not needed for PostgreSQL/GORM
 // GORM works directly with models, no need for collections
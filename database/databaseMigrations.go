@@ -0,0 +1,55 @@
+package database
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// DropLegacyTokenColumns removes the token and refresh_token columns of the users
+// table. They held plaintext tokens before sessions existed, and AutoMigrate never
+// drops columns on its own.
+func DropLegacyTokenColumns(client *gorm.DB) {
+    for _, column := range []string{"token", "refresh_token", "token_family"} {
+        if !client.Migrator().HasColumn("users", column) {
+            continue
+        }
+        if err := client.Migrator().DropColumn("users", column); err != nil {
+            log.Fatal(err)
+        }
+        log.Printf("Dropped legacy users.%s column", column)
+    }
+}
+
+// legacyOAuthPassword is what Google sign-in used to store as the password of the
+// accounts it created, in plaintext.
+const legacyOAuthPassword = "google-oauth-user"
+
+// ClearPlaceholderPasswords turns the accounts created by the old Google sign-in
+// into social-only accounts (no password). They get their identity row on their
+// next Google login, see helpers.FindOrCreateIdentityUser.
+func ClearPlaceholderPasswords(client *gorm.DB) {
+    // AutoMigrate should have done this, but it must hold before the update below
+    if err := client.Exec("ALTER TABLE users ALTER COLUMN password DROP NOT NULL").Error; err != nil {
+        log.Fatal(err)
+    }
+    result := client.Exec("UPDATE users SET password = NULL WHERE password = ?", legacyOAuthPassword)
+    if result.Error != nil {
+        log.Fatal(result.Error)
+    }
+    if result.RowsAffected > 0 {
+        log.Printf("Cleared the placeholder password of %d Google accounts", result.RowsAffected)
+    }
+}
+
+// DropGlobalRecoveryCodeIndex removes the old unique index on recovery_codes.code_hash.
+// Codes are only unique per user now, and AutoMigrate never drops indexes on its own.
+func DropGlobalRecoveryCodeIndex(client *gorm.DB) {
+    if !client.Migrator().HasIndex("recovery_codes", "idx_recovery_codes_code_hash") {
+        return
+    }
+    if err := client.Migrator().DropIndex("recovery_codes", "idx_recovery_codes_code_hash"); err != nil {
+        log.Fatal(err)
+    }
+    log.Println("Dropped the global recovery_codes.code_hash index")
+}
@@ -0,0 +1,39 @@
+// Package databasetest gives tests an in-memory SQLite database in place of Postgres.
+package databasetest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Aaryansingh20/jwt/database"
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Setup creates the tables in a fresh database and makes it database.Client for
+// the rest of the test.
+func Setup(t testing.TB) *gorm.DB {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection of an in-memory database is a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(models.Tables()...); err != nil {
+		t.Fatal(err)
+	}
+	database.Use(db)
+	return db
+}
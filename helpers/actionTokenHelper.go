@@ -0,0 +1,199 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// IssueActionToken mints a signed single-use token for purpose (it ends up in the
+// Token_type claim, so it is never accepted as an access token). Unused tokens the
+// user got earlier for the same purpose stop working.
+func IssueActionToken(user models.User, purpose string, ttl time.Duration) (string, error) {
+	return issueActionToken(user, purpose, ttl, nil)
+}
+
+// how many wrong codes a row takes before it is burnt. With 6 digits that leaves
+// an attacker a 1 in 200000 chance per emailed code.
+const maxActionCodeAttempts = 5
+
+// IssueActionTokenWithCode is IssueActionToken plus a 6 digit code for the same row,
+// for users who would rather type the code than open the link on this device.
+// Either one can be redeemed, whichever comes first uses the row up.
+func IssueActionTokenWithCode(user models.User, purpose string, ttl time.Duration) (token string, code string, err error) {
+	code, err = generateNumericCode(6)
+	if err != nil {
+		return "", "", err
+	}
+	codeHash := HashToken(code)
+	token, err = issueActionToken(user, purpose, ttl, &codeHash)
+	if err != nil {
+		return "", "", err
+	}
+	return token, code, nil
+}
+
+func issueActionToken(user models.User, purpose string, ttl time.Duration, codeHash *string) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Email:      *user.Email,
+		Uid:        user.User_id,
+		Token_type: purpose,
+		Issued_us:  now.UnixMicro(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ttl).Unix(),
+		},
+	}
+	signed, err := signClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	// only the newest link of a kind is valid
+	err = userDB.Model(&models.ActionToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.User_id, purpose).
+		Update("used_at", now).Error
+	if err != nil {
+		return "", err
+	}
+
+	actionToken := models.ActionToken{
+		Token_id:   claims.Id,
+		User_id:    user.User_id,
+		Purpose:    purpose,
+		Expires_at: now.Add(ttl),
+		Code_hash:  codeHash,
+		Created_at: now,
+	}
+	if err := userDB.Create(&actionToken).Error; err != nil {
+		return "", err
+	}
+	return signed, nil
+}
+
+// PeekActionToken checks the token like ConsumeActionToken, without using it up.
+// For flows where something else (like a TOTP code) must be checked first.
+func PeekActionToken(signedToken string, purpose string) (claims *SignedDetails, msg string) {
+	claims, msg = parseToken(signedToken)
+	if msg != "" {
+		return
+	}
+	if claims.Token_type != purpose || claims.Id == "" {
+		return nil, "the token is invalid"
+	}
+
+	var count int64
+	userDB.Model(&models.ActionToken{}).
+		Where("token_id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", claims.Id, claims.Uid, purpose, time.Now(), maxActionCodeAttempts).
+		Count(&count)
+	if count != 1 {
+		return nil, fmt.Sprintf("the token has already been used")
+	}
+	return claims, msg
+}
+
+// ReserveActionTokenAttempt counts a try at the code of a token checked with
+// PeekActionToken, it has to be called before the code is compared. Counting and
+// checking maxActionCodeAttempts is one UPDATE, so parallel guesses can't get more
+// tries than that between them.
+func ReserveActionTokenAttempt(claims *SignedDetails) (msg string) {
+	return reserveActionCodeAttempt("token_id = ?", claims.Id)
+}
+
+func reserveActionCodeAttempt(query string, args ...interface{}) (msg string) {
+	result := userDB.Model(&models.ActionToken{}).
+		Where(query, args...).
+		Where("used_at IS NULL AND attempts < ?", maxActionCodeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error.Error()
+	}
+	if result.RowsAffected != 1 {
+		return "the token has already been used"
+	}
+	return ""
+}
+
+// ConsumeActionToken checks the token was minted for purpose and marks it used.
+// A second call with the same token fails.
+func ConsumeActionToken(signedToken string, purpose string) (claims *SignedDetails, msg string) {
+	claims, msg = parseToken(signedToken)
+	if msg != "" {
+		return
+	}
+	if claims.Token_type != purpose || claims.Id == "" {
+		return nil, "the token is invalid"
+	}
+
+	result := userDB.Model(&models.ActionToken{}).
+		Where("token_id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", claims.Id, claims.Uid, purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error.Error()
+	}
+	if result.RowsAffected != 1 {
+		return nil, fmt.Sprintf("the token has already been used")
+	}
+	return claims, msg
+}
+
+// ConsumeActionCode is ConsumeActionToken for the typed code of the user's newest
+// token for purpose. Every try counts against the row, see maxActionCodeAttempts.
+func ConsumeActionCode(userId string, purpose string, code string) (msg string) {
+	var actionToken models.ActionToken
+	err := userDB.Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ? AND code_hash IS NOT NULL", userId, purpose, time.Now()).
+		Order("created_at desc").
+		First(&actionToken).Error
+	if err != nil {
+		return "the code is invalid or expired"
+	}
+	if msg := reserveActionCodeAttempt("id = ?", actionToken.ID); msg != "" {
+		return "the code is invalid or expired"
+	}
+	if !hmac.Equal([]byte(HashToken(code)), []byte(*actionToken.Code_hash)) {
+		return "the code is invalid or expired"
+	}
+
+	result := userDB.Model(&models.ActionToken{}).
+		Where("id = ? AND used_at IS NULL", actionToken.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error.Error()
+	}
+	if result.RowsAffected != 1 {
+		return fmt.Sprintf("the code has already been used")
+	}
+	return ""
+}
+
+// generateNumericCode returns a uniformly random code of n decimal digits.
+func generateNumericCode(n int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	value, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, value), nil
+}
+
+// LastActionTokenIssuedAt is when the user last got a token for purpose, zero if never.
+// It is what resend endpoints throttle on.
+func LastActionTokenIssuedAt(userId string, purpose string) time.Time {
+	var actionToken models.ActionToken
+	err := userDB.Where("user_id = ? AND purpose = ?", userId, purpose).
+		Order("created_at desc").
+		First(&actionToken).Error
+	if err != nil {
+		return time.Time{}
+	}
+	return actionToken.Created_at
+}
@@ -0,0 +1,49 @@
+package helpers_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+)
+
+func TestParallelGuessesDontGetPastTheCodeLimit(t *testing.T) {
+	db := databasetest.Setup(t)
+	email := "ada@example.com"
+	user := models.User{User_id: "user-1", Email: &email}
+	_, code, err := helper.IssueActionTokenWithCode(user, helper.MagicLinkTokenType, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "000001"
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if msg := helper.ConsumeActionCode(user.User_id, helper.MagicLinkTokenType, wrong); msg == "" {
+				t.Error("a wrong code was accepted")
+			}
+		}()
+	}
+	wg.Wait()
+
+	var actionToken models.ActionToken
+	if err := db.First(&actionToken).Error; err != nil {
+		t.Fatal(err)
+	}
+	if actionToken.Attempts != 5 {
+		t.Fatalf("want 5 counted tries, got %d", actionToken.Attempts)
+	}
+	// the right code is too late now
+	if msg := helper.ConsumeActionCode(user.User_id, helper.MagicLinkTokenType, code); msg == "" {
+		t.Fatal("the code still works after the limit")
+	}
+}
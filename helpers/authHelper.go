@@ -6,7 +6,12 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// CheckUserType lets through users of the given type. Machine clients have no user
+// type and never pass, routes open to them check with CheckScopeOrAdmin.
 func CheckUserType(c *gin.Context, userOrAdmin string) (err error) {
+	if c.GetString("principal_type") != UserPrincipal {
+		return errors.New("Not authorized to access the resource")
+	}
 	userType := c.GetString("user_type")
 	err = nil
 	if userType != userOrAdmin {
@@ -16,18 +21,30 @@ func CheckUserType(c *gin.Context, userOrAdmin string) (err error) {
 	return err
 }
 
+// CheckScopeOrAdmin is for the routes machine clients may call as well: a client
+// needs scope, a user has to be an ADMIN.
+func CheckScopeOrAdmin(c *gin.Context, scope string) error {
+	if c.GetString("principal_type") == ClientPrincipal {
+		if !ScopeIncludes(c.GetString("scopes"), scope) {
+			return errors.New("Not authorized to access the resource")
+		}
+		return nil
+	}
+	return CheckUserType(c, "ADMIN")
+}
+
 func MatchUserTypeToUserId(c *gin.Context, userId string) (err error) {
 	//  This is the match user function
+	if c.GetString("principal_type") != UserPrincipal {
+		return errors.New("You are not authorized to access this user")
+	}
 	userType := c.GetString("user_type")
 	uid := c.GetString("uid")
-	err = nil
 
 	// this means that user is USER not ADMIN and uid is not of the user. Because user can only access his id,
 	// admin can access anyone's id
-	if userId == "USER" && uid != userId {
-		err = errors.New("You are not authorized to access this user")
+	if userType != "ADMIN" && uid != userId {
+		return errors.New("You are not authorized to access this user")
 	}
-	err = CheckUserType(c, userType)
-	return err
-
+	return nil
 }
\ No newline at end of file
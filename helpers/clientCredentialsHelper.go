@@ -0,0 +1,56 @@
+package helpers
+
+import (
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// ServiceScopes are the scopes of machine clients, each one opens a few of the
+// protected routes (see routes/userRouter.go).
+var ServiceScopes = []string{"users:read", "users:write"}
+
+// ClientTokenLifetime is how long a client_credentials token lasts. There is no
+// refresh token, the client simply asks for a new one.
+var ClientTokenLifetime = EnvDuration("CLIENT_TOKEN_TTL", time.Hour)
+
+// GenerateClientToken mints an access token for a machine client. It speaks for the
+// client itself, there is no user: Uid is empty and the subject is the client id.
+// Family_id is the client id too, so deleting the client revokes all its tokens.
+func GenerateClientToken(client models.OAuthClient, scope string) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Token_type:     AccessTokenType,
+		Family_id:      client.Client_id,
+		Client_id:      client.Client_id,
+		Scope:          scope,
+		Principal_type: ClientPrincipal,
+		Issued_us:      now.UnixMicro(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   client.Client_id,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(ClientTokenLifetime).Unix(),
+		},
+	}
+	return signClaims(claims)
+}
+
+// ValidScopes reports whether every scope is one of allowed.
+func ValidScopes(scopes []string, allowed []string) bool {
+	for _, scope := range scopes {
+		found := false
+		for _, a := range allowed {
+			if scope == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
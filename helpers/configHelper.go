@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FrontendURL is where the links we hand out (in emails, redirects) point to.
+func FrontendURL() string {
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// EnvBool reads a boolean setting, anything but true/1/yes is false.
+func EnvBool(name string) bool {
+	switch strings.ToLower(os.Getenv(name)) {
+	case "true", "1", "yes":
+		return true
+	}
+	return false
+}
+
+// EnvDuration reads a duration setting like "15m", falling back when unset or invalid.
+func EnvDuration(name string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// EnvInt reads an integer setting, falling back when unset or invalid.
+func EnvInt(name string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+	return fallback
+}
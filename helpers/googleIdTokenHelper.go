@@ -0,0 +1,261 @@
+package helpers
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var ErrInvalidGoogleIDToken = errors.New("the Google ID token is invalid")
+
+// Google ID tokens are checked against the keys at GOOGLE_JWKS_URL, issued by one of
+// GOOGLE_ID_TOKEN_ISSUERS and for one of GOOGLE_ID_TOKEN_AUDIENCES (the client ids of
+// the apps, defaults to GOOGLE_CLIENT_ID). The first two only change for tests.
+var (
+	googleJWKSURL = func() string {
+		if url := os.Getenv("GOOGLE_JWKS_URL"); url != "" {
+			return url
+		}
+		return "https://www.googleapis.com/oauth2/v3/certs"
+	}()
+	googleIssuers = func() []string {
+		if issuers := os.Getenv("GOOGLE_ID_TOKEN_ISSUERS"); issuers != "" {
+			return splitList(issuers)
+		}
+		return []string{"https://accounts.google.com", "accounts.google.com"}
+	}()
+	googleAudiences = func() []string {
+		if audiences := os.Getenv("GOOGLE_ID_TOKEN_AUDIENCES"); audiences != "" {
+			return splitList(audiences)
+		}
+		if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+			return []string{clientID}
+		}
+		return nil
+	}()
+)
+
+// GoogleIDTokenClaims is what we read from a Google ID token.
+type GoogleIDTokenClaims struct {
+	Email          string `json:"email"`
+	Email_verified bool   `json:"email_verified"`
+	Name           string `json:"name"`
+	Given_name     string `json:"given_name"`
+	Family_name    string `json:"family_name"`
+	Picture        string `json:"picture"`
+	jwt.StandardClaims
+}
+
+// GoogleIDTokensEnabled reports whether an audience is configured, without one no
+// ID token can be accepted.
+func GoogleIDTokensEnabled() bool {
+	return len(googleAudiences) > 0
+}
+
+// VerifyGoogleIDToken checks the signature, issuer, audience, expiry and that the
+// email is verified, and returns the login the token stands for.
+func VerifyGoogleIDToken(idToken string) (OAuthLogin, error) {
+	token, err := jwt.ParseWithClaims(idToken, &GoogleIDTokenClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return googleKeys.key(kid)
+	})
+	if err != nil {
+		return OAuthLogin{}, fmt.Errorf("%w: %v", ErrInvalidGoogleIDToken, err)
+	}
+	claims, ok := token.Claims.(*GoogleIDTokenClaims)
+	if !ok || !token.Valid {
+		return OAuthLogin{}, ErrInvalidGoogleIDToken
+	}
+
+	// Valid() already checked exp, iat and nbf
+	if !containsString(googleIssuers, claims.Issuer) {
+		return OAuthLogin{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidGoogleIDToken)
+	}
+	if !containsString(googleAudiences, claims.Audience) {
+		return OAuthLogin{}, fmt.Errorf("%w: unexpected audience", ErrInvalidGoogleIDToken)
+	}
+	if claims.Subject == "" || claims.Email == "" || !claims.Email_verified {
+		return OAuthLogin{}, fmt.Errorf("%w: the email is not verified", ErrInvalidGoogleIDToken)
+	}
+
+	firstName := claims.Given_name
+	if firstName == "" {
+		firstName = claims.Name
+	}
+	return OAuthLogin{
+		Identity: ExternalIdentity{
+			// the same provider and sub the redirect flow gets, so both find the same identity
+			Provider:       googleIDTokenProvider(),
+			Subject:        claims.Subject,
+			Email:          claims.Email,
+			Email_verified: true,
+			First_name:     firstName,
+			Last_name:      claims.Family_name,
+		},
+		Extra: map[string]string{"picture": claims.Picture},
+	}, nil
+}
+
+// googleIDTokenProvider is the provider name ID token logins are stored under:
+// GOOGLE_ID_TOKEN_PROVIDER, else the configured provider of type google (the first
+// by name if there are several), else "google".
+func googleIDTokenProvider() string {
+	if name := os.Getenv("GOOGLE_ID_TOKEN_PROVIDER"); name != "" {
+		return strings.ToLower(name)
+	}
+	names := make([]string, 0, len(oauthProviders))
+	for name, config := range oauthProviders {
+		if config.Type == "google" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "google"
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksCache holds the keys of a JWKS URL for as long as its Cache-Control allows
+// (an hour when it says nothing). An unknown kid reloads the keys, at most once a minute.
+type jwksCache struct {
+	mu        sync.Mutex
+	url       string
+	keys      map[string]*rsa.PublicKey
+	expiresAt time.Time
+	fetchedAt time.Time
+	// held for a fetch, so a slow JWKS URL only holds up the requests that need
+	// new keys and there is one fetch at a time
+	reload sync.Mutex
+}
+
+var googleKeys = &jwksCache{url: googleJWKSURL}
+
+var maxAgePattern = regexp.MustCompile(`max-age=(\d+)`)
+
+func (cache *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	if key, found, stale := cache.lookup(kid); found || !stale {
+		if found {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	cache.reload.Lock()
+	defer cache.reload.Unlock()
+	// somebody else may have fetched while we waited
+	if key, found, stale := cache.lookup(kid); found || !stale {
+		if found {
+			return key, nil
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	keys, maxAge, err := cache.fetch()
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now()
+	if err == nil {
+		cache.keys = keys
+		cache.expiresAt = time.Now().Add(maxAge)
+	}
+	cache.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if key, found := keys[kid]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// lookup is the cached key of kid, found only while the keys haven't expired.
+// stale says whether a fetch is due: the keys expired, or kid is unknown and the
+// last fetch is more than a minute ago.
+func (cache *jwksCache) lookup(kid string) (key *rsa.PublicKey, found bool, stale bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	expired := !time.Now().Before(cache.expiresAt)
+	key, found = cache.keys[kid]
+	if found && !expired {
+		return key, true, false
+	}
+	return nil, false, expired || time.Since(cache.fetchedAt) > time.Minute
+}
+
+// fetch loads the keys and how long they may be cached, without touching the cache.
+func (cache *jwksCache) fetch() (map[string]*rsa.PublicKey, time.Duration, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(cache.url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("fetching %s: %s", cache.url, response.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&jwks); err != nil {
+		return nil, 0, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	maxAge := time.Hour
+	if match := maxAgePattern.FindStringSubmatch(response.Header.Get("Cache-Control")); match != nil {
+		if seconds, err := strconv.Atoi(match[1]); err == nil {
+			maxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return keys, maxAge, nil
+}
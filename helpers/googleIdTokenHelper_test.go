@@ -0,0 +1,236 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// jwksServer serves the public halves of its keys like Google's certs endpoint.
+type jwksServer struct {
+	*httptest.Server
+	mu           sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	cacheControl string
+	fetches      int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	t.Helper()
+	server := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		server.fetches++
+		type jwk struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		}
+		var jwks struct {
+			Keys []jwk `json:"keys"`
+		}
+		for kid, key := range server.keys {
+			jwks.Keys = append(jwks.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		if server.cacheControl != "" {
+			w.Header().Set("Cache-Control", server.cacheControl)
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *jwksServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	server.keys[kid] = key
+	server.mu.Unlock()
+	return key
+}
+
+func (server *jwksServer) fetchCount() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.fetches
+}
+
+// useGoogleTestConfig points the Google ID token checks at server for the test.
+func useGoogleTestConfig(t *testing.T, server *jwksServer) {
+	t.Helper()
+	oldKeys, oldIssuers, oldAudiences := googleKeys, googleIssuers, googleAudiences
+	googleKeys = &jwksCache{url: server.URL}
+	googleIssuers = []string{"https://accounts.google.com"}
+	googleAudiences = []string{"web-client-id", "ios-client-id"}
+	t.Cleanup(func() {
+		googleKeys, googleIssuers, googleAudiences = oldKeys, oldIssuers, oldAudiences
+	})
+}
+
+func validGoogleClaims() GoogleIDTokenClaims {
+	now := time.Now()
+	return GoogleIDTokenClaims{
+		Email:          "ada@example.com",
+		Email_verified: true,
+		Given_name:     "Ada",
+		Family_name:    "Lovelace",
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "1234567890",
+			Issuer:    "https://accounts.google.com",
+			Audience:  "ios-client-id",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func signGoogleIDToken(t *testing.T, key *rsa.PrivateKey, kid string, claims GoogleIDTokenClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyGoogleIDToken(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	useGoogleTestConfig(t, server)
+
+	login, err := VerifyGoogleIDToken(signGoogleIDToken(t, key, "key-1", validGoogleClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := login.Identity
+	if identity.Provider != "google" || identity.Subject != "1234567890" || identity.Email != "ada@example.com" ||
+		!identity.Email_verified || identity.First_name != "Ada" || identity.Last_name != "Lovelace" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	otherKey := server.addKey(t, "key-2")
+	tests := []struct {
+		name   string
+		key    *rsa.PrivateKey
+		change func(*GoogleIDTokenClaims)
+	}{
+		{"wrong audience", key, func(claims *GoogleIDTokenClaims) { claims.Audience = "somebody-else" }},
+		{"wrong issuer", key, func(claims *GoogleIDTokenClaims) { claims.Issuer = "https://evil.example.com" }},
+		{"expired", key, func(claims *GoogleIDTokenClaims) { claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() }},
+		{"email not verified", key, func(claims *GoogleIDTokenClaims) { claims.Email_verified = false }},
+		{"no subject", key, func(claims *GoogleIDTokenClaims) { claims.Subject = "" }},
+		{"signed by another key", otherKey, func(claims *GoogleIDTokenClaims) {}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := validGoogleClaims()
+			test.change(&claims)
+			_, err := VerifyGoogleIDToken(signGoogleIDToken(t, test.key, "key-1", claims))
+			if !errors.Is(err, ErrInvalidGoogleIDToken) {
+				t.Fatalf("want ErrInvalidGoogleIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGoogleIDTokenUsesTheConfiguredProviderName(t *testing.T) {
+	server := newJWKSServer(t)
+	key := server.addKey(t, "key-1")
+	useGoogleTestConfig(t, server)
+	oauthProviders["workspace"] = OAuthProviderConfig{Name: "workspace", Type: "google"}
+	t.Cleanup(func() { delete(oauthProviders, "workspace") })
+
+	login, err := VerifyGoogleIDToken(signGoogleIDToken(t, key, "key-1", validGoogleClaims()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Identity.Provider != "workspace" {
+		t.Fatalf("want the identity under the provider of the redirect flow, got %q", login.Identity.Provider)
+	}
+}
+
+func TestJWKSCacheRefetchesForAnUnknownKid(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "key-1")
+	cache := &jwksCache{url: server.URL}
+
+	if _, err := cache.key("key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.key("key-1"); err != nil || server.fetchCount() != 1 {
+		t.Fatalf("a known key was fetched again: %v, %d fetches", err, server.fetchCount())
+	}
+
+	// Google rotated, the new kid shows up before our cache expired
+	server.addKey(t, "key-2")
+	if _, err := cache.key("key-2"); err == nil || server.fetchCount() != 1 {
+		t.Fatalf("refetched within a minute of the last fetch: %v, %d fetches", err, server.fetchCount())
+	}
+	cache.mu.Lock()
+	cache.fetchedAt = time.Now().Add(-2 * time.Minute)
+	cache.mu.Unlock()
+	if _, err := cache.key("key-2"); err != nil || server.fetchCount() != 2 {
+		t.Fatalf("the new key was not fetched: %v, %d fetches", err, server.fetchCount())
+	}
+}
+
+func TestJWKSCacheFollowsCacheControl(t *testing.T) {
+	server := newJWKSServer(t)
+	server.addKey(t, "key-1")
+
+	for _, test := range []struct {
+		cacheControl string
+		maxAge       time.Duration
+	}{
+		{"public, max-age=120, must-revalidate, no-transform", 2 * time.Minute},
+		{"", time.Hour},
+	} {
+		server.mu.Lock()
+		server.cacheControl = test.cacheControl
+		server.mu.Unlock()
+		cache := &jwksCache{url: server.URL}
+		if _, err := cache.key("key-1"); err != nil {
+			t.Fatal(err)
+		}
+		cache.mu.Lock()
+		maxAge := time.Until(cache.expiresAt)
+		cache.mu.Unlock()
+		if maxAge > test.maxAge || maxAge < test.maxAge-time.Minute {
+			t.Errorf("Cache-Control %q: cached for %v, want %v", test.cacheControl, maxAge, test.maxAge)
+		}
+	}
+
+	// once expired the keys are fetched again, even for a known kid
+	cache := &jwksCache{url: server.URL}
+	cache.key("key-1")
+	fetches := server.fetchCount()
+	cache.mu.Lock()
+	cache.expiresAt = time.Now().Add(-time.Second)
+	cache.mu.Unlock()
+	if _, err := cache.key("key-1"); err != nil || server.fetchCount() != fetches+1 {
+		t.Fatalf("expired keys were not fetched again: %v", err)
+	}
+}
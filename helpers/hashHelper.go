@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+)
+
+// TOKEN_HASH_KEY keys the hashes of the tokens we store (refresh tokens and the like).
+// Without the key a database dump is useless, hashes can't be matched to tokens.
+var TOKEN_HASH_KEY = func() string {
+	if key := os.Getenv("TOKEN_HASH_KEY"); key != "" {
+		return key
+	}
+	if SECRET_KEY != "" {
+		log.Println("⚠️  TOKEN_HASH_KEY is not set, falling back to SECRET_KEY for token hashes")
+	}
+	return SECRET_KEY
+}()
+
+// CheckTokenHashKey fails when there is no key for HashToken. SECRET_KEY is only
+// needed for HS256, with an asymmetric JWT_SIGNING_ALG it is often unset and the
+// hashes would be keyed with nothing.
+func CheckTokenHashKey() error {
+	if TOKEN_HASH_KEY == "" {
+		return errors.New("TOKEN_HASH_KEY (or SECRET_KEY) must be set, it keys the stored token hashes")
+	}
+	return nil
+}
+
+// HashToken is the keyed hash (HMAC-SHA256) a token is stored and looked up by.
+func HashToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(TOKEN_HASH_KEY))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"errors"
+	"expvar"
+	"runtime"
+	"time"
+)
+
+var ErrHashPoolBusy = errors.New("too many password hashes in progress, try again later")
+
+// Password hashes are slow on purpose, so at most HASH_CONCURRENCY of them run at
+// once (default: one per CPU) and the rest wait in line for HASH_QUEUE_TIMEOUT.
+// A burst of logins then gets ErrHashPoolBusy instead of starving every other request.
+var (
+	hashConcurrency  = EnvInt("HASH_CONCURRENCY", runtime.NumCPU())
+	HashQueueTimeout = EnvDuration("HASH_QUEUE_TIMEOUT", 2*time.Second)
+
+	hashSlots = make(chan struct{}, max(hashConcurrency, 1))
+)
+
+// metrics of the pool, served by expvar under "password_hash"
+var (
+	hashMetrics        = expvar.NewMap("password_hash")
+	hashQueueDepth     = new(expvar.Int)   // waiting for a slot right now
+	hashInFlight       = new(expvar.Int)   // hashing right now
+	hashTotal          = new(expvar.Int)   // hashes done
+	hashRejected       = new(expvar.Int)   // gave up waiting, answered with ErrHashPoolBusy
+	hashSecondsTotal   = new(expvar.Float) // time spent hashing
+	hashWaitSecondsSum = new(expvar.Float) // time spent waiting for a slot
+	hashLastMillis     = new(expvar.Float) // latency of the last hash
+)
+
+func init() {
+	hashMetrics.Set("queue_depth", hashQueueDepth)
+	hashMetrics.Set("in_flight", hashInFlight)
+	hashMetrics.Set("total", hashTotal)
+	hashMetrics.Set("rejected_total", hashRejected)
+	hashMetrics.Set("hash_seconds_total", hashSecondsTotal)
+	hashMetrics.Set("wait_seconds_total", hashWaitSecondsSum)
+	hashMetrics.Set("last_hash_ms", hashLastMillis)
+	hashMetrics.Set("concurrency", expvarInt(int64(cap(hashSlots))))
+}
+
+func expvarInt(value int64) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(value)
+	return v
+}
+
+// withHashSlot runs hash once a slot is free, or fails with ErrHashPoolBusy
+// when none frees up within HashQueueTimeout.
+func withHashSlot(hash func()) error {
+	queuedAt := time.Now()
+	hashQueueDepth.Add(1)
+	timer := time.NewTimer(HashQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case hashSlots <- struct{}{}:
+		hashQueueDepth.Add(-1)
+	case <-timer.C:
+		hashQueueDepth.Add(-1)
+		hashRejected.Add(1)
+		return ErrHashPoolBusy
+	}
+	defer func() { <-hashSlots }()
+
+	startedAt := time.Now()
+	hashWaitSecondsSum.Add(startedAt.Sub(queuedAt).Seconds())
+	hashInFlight.Add(1)
+	hash()
+	hashInFlight.Add(-1)
+
+	took := time.Since(startedAt)
+	hashTotal.Add(1)
+	hashSecondsTotal.Add(took.Seconds())
+	hashLastMillis.Set(float64(took) / float64(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,213 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LinkIdentityTokenType is the action token handed out when a provider login hits
+// an existing account, redeemed at the link endpoint together with the proof.
+const LinkIdentityTokenType = "link_identity"
+
+var (
+	// ErrIdentityLinkRequired means the email belongs to an existing account that
+	// has to prove it wants the provider linked, the user is returned along with it.
+	ErrIdentityLinkRequired = errors.New("an account with this email already exists, link it first")
+	ErrIdentityNotFound     = errors.New("identity not found")
+	ErrLastLoginMethod      = errors.New("this is the only way to log in to the account, set a password first")
+	ErrProviderLinked       = errors.New("the account is already linked to another account of this provider")
+	// ErrEmailNotVerified means the provider doesn't vouch for the email, anybody
+	// could have typed it in there. Only identities linked before still log in.
+	ErrEmailNotVerified = errors.New("the provider has not verified this email address")
+)
+
+// ExternalIdentity is a user as a provider vouched for them.
+type ExternalIdentity struct {
+	Provider       string
+	Subject        string // the stable id of the user at the provider, never the email
+	Email          string
+	Email_verified bool
+	First_name     string
+	Last_name      string
+}
+
+// FindOrCreateIdentityUser is the account behind a provider login:
+//   - the user the identity is linked to,
+//   - a new social-only user (no password) when nobody has the email yet,
+//   - ErrIdentityLinkRequired when somebody does. The provider is never linked
+//     to an existing account on its own, see CreatePendingIdentity.
+//
+// Only an identity that is linked already may come with an unverified email,
+// nothing is created or linked for one, see CheckIdentityEmail.
+//
+// The one exception are the password-less accounts made by the old Google sign-in
+// before identities existed, they only ever came from Google and are linked right away.
+func FindOrCreateIdentityUser(identity ExternalIdentity) (models.User, error) {
+	var user models.User
+	if identity.Provider == "" || identity.Subject == "" || identity.Email == "" {
+		return user, errors.New("the provider did not return a user id and email")
+	}
+
+	var linked models.UserIdentity
+	err := userDB.Where("provider = ? AND provider_user_id = ? AND linked_at IS NOT NULL", identity.Provider, identity.Subject).
+		First(&linked).Error
+	if err == nil {
+		err = userDB.Where("user_id = ?", linked.User_id).First(&user).Error
+		return user, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+	if !identity.Email_verified {
+		return user, ErrEmailNotVerified
+	}
+
+	err = userDB.Where("email = ?", identity.Email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return createIdentityUser(identity)
+	}
+	if err != nil {
+		return user, err
+	}
+
+	if identity.Provider == "google" && user.Password == nil {
+		var count int64
+		userDB.Model(&models.UserIdentity{}).Where("user_id = ?", user.User_id).Count(&count)
+		if count == 0 {
+			return user, linkIdentity(userDB, user.User_id, identity, time.Now())
+		}
+	}
+	return user, ErrIdentityLinkRequired
+}
+
+// CheckIdentityEmail is ErrEmailNotVerified for a login FindOrCreateIdentityUser
+// would refuse, so the redirect flow can say so before handing out a login code.
+func CheckIdentityEmail(identity ExternalIdentity) error {
+	if identity.Email_verified {
+		return nil
+	}
+	var count int64
+	err := userDB.Model(&models.UserIdentity{}).
+		Where("provider = ? AND provider_user_id = ? AND linked_at IS NOT NULL", identity.Provider, identity.Subject).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+func createIdentityUser(identity ExternalIdentity) (models.User, error) {
+	now := time.Now()
+	phone := ""
+	userType := "USER"
+	user := models.User{
+		First_name: &identity.First_name,
+		Last_name:  &identity.Last_name,
+		Email:      &identity.Email,
+		Phone:      &phone,
+		User_type:  &userType,
+		Created_at: now,
+		Updated_at: now,
+		User_id:    uuid.New().String(),
+		// no password, the provider is the only way in until the user sets one
+		// and the provider verified the email, see FindOrCreateIdentityUser
+		Email_verified:    true,
+		Email_verified_at: &now,
+	}
+
+	err := userDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return linkIdentity(tx, user.User_id, identity, now)
+	})
+	return user, err
+}
+
+func linkIdentity(tx *gorm.DB, userId string, identity ExternalIdentity, now time.Time) error {
+	row := models.UserIdentity{
+		User_id:          userId,
+		Provider:         identity.Provider,
+		Provider_user_id: identity.Subject,
+		Email:            identity.Email,
+		Linked_at:        &now,
+		Created_at:       now,
+	}
+	return tx.Create(&row).Error
+}
+
+// CreatePendingIdentity remembers the identity a user has to confirm with the link
+// token. A user has at most one pending identity, the one of the newest token.
+func CreatePendingIdentity(userId string, identity ExternalIdentity) error {
+	return userDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("linked_at IS NULL AND (user_id = ? OR (provider = ? AND provider_user_id = ?))", userId, identity.Provider, identity.Subject).
+			Delete(&models.UserIdentity{}).Error
+		if err != nil {
+			return err
+		}
+		var count int64
+		tx.Model(&models.UserIdentity{}).Where("user_id = ? AND provider = ?", userId, identity.Provider).Count(&count)
+		if count > 0 {
+			return ErrProviderLinked
+		}
+		row := models.UserIdentity{
+			User_id:          userId,
+			Provider:         identity.Provider,
+			Provider_user_id: identity.Subject,
+			Email:            identity.Email,
+			Created_at:       time.Now(),
+		}
+		return tx.Create(&row).Error
+	})
+}
+
+// ConfirmPendingIdentity links the pending identity of the user, call it once the
+// user proved they own the account.
+func ConfirmPendingIdentity(userId string) (models.UserIdentity, error) {
+	var row models.UserIdentity
+	if err := userDB.Where("user_id = ? AND linked_at IS NULL", userId).First(&row).Error; err != nil {
+		return row, ErrIdentityNotFound
+	}
+	now := time.Now()
+	if err := userDB.Model(&row).Update("linked_at", now).Error; err != nil {
+		return row, err
+	}
+	row.Linked_at = &now
+	return row, nil
+}
+
+// ListIdentities returns the linked identities of the user.
+func ListIdentities(userId string) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := userDB.Where("user_id = ? AND linked_at IS NOT NULL", userId).Order("linked_at").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkIdentity removes the user's identity of provider, unless it is the only way
+// left to log in (no password, no passkey, no other identity).
+func UnlinkIdentity(user models.User, provider string) error {
+	provider = strings.ToLower(provider)
+
+	var row models.UserIdentity
+	if err := userDB.Where("user_id = ? AND provider = ? AND linked_at IS NOT NULL", user.User_id, provider).First(&row).Error; err != nil {
+		return ErrIdentityNotFound
+	}
+
+	if user.Password == nil {
+		var others, passkeys int64
+		userDB.Model(&models.UserIdentity{}).Where("user_id = ? AND linked_at IS NOT NULL AND id <> ?", user.User_id, row.ID).Count(&others)
+		userDB.Model(&models.PasskeyCredential{}).Where("user_id = ?", user.User_id).Count(&passkeys)
+		if others == 0 && passkeys == 0 {
+			return ErrLastLoginMethod
+		}
+	}
+	return userDB.Delete(&row).Error
+}
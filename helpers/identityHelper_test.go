@@ -0,0 +1,55 @@
+package helpers_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+)
+
+func TestUnverifiedEmailsNeverCreateOrLinkAccounts(t *testing.T) {
+	db := databasetest.Setup(t)
+	identity := helper.ExternalIdentity{
+		Provider: "gitlab",
+		Subject:  "42",
+		Email:    "ada@example.com",
+	}
+
+	if _, err := helper.FindOrCreateIdentityUser(identity); !errors.Is(err, helper.ErrEmailNotVerified) {
+		t.Fatalf("want ErrEmailNotVerified for a new account, got %v", err)
+	}
+	var count int64
+	db.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Fatal("an account was created for an unverified email")
+	}
+
+	identity.Email_verified = true
+	user, err := helper.FindOrCreateIdentityUser(identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Email_verified {
+		t.Error("the provider verified the email, the account should say so")
+	}
+
+	// once linked the subject is what counts, the email may change at the provider
+	identity.Email_verified = false
+	identity.Email = "ada@elsewhere.example.com"
+	if err := helper.CheckIdentityEmail(identity); err != nil {
+		t.Fatalf("a linked identity was refused: %v", err)
+	}
+	again, err := helper.FindOrCreateIdentityUser(identity)
+	if err != nil || again.User_id != user.User_id {
+		t.Fatalf("want the linked user, got %v %v", again.User_id, err)
+	}
+
+	// another provider account with the same, unverified, email is not linked to it
+	identity.Subject = "43"
+	identity.Email = "ada@example.com"
+	if _, err := helper.FindOrCreateIdentityUser(identity); !errors.Is(err, helper.ErrEmailNotVerified) {
+		t.Fatalf("want ErrEmailNotVerified for a link, got %v", err)
+	}
+}
@@ -0,0 +1,476 @@
+package helpers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// SigningKey is one entry of the key ring. PrivateKey is nil for keys we can only
+// verify with (e.g. the public half of a key that lives on another instance).
+type SigningKey struct {
+	Kid        string
+	Method     jwt.SigningMethod
+	PrivateKey interface{}
+	PublicKey  interface{}
+	Retired_at time.Time // zero while the key is still current
+}
+
+// expired reports whether every token the key could have signed is expired by now,
+// which is when a retired key stops being accepted.
+func (key *SigningKey) expired() bool {
+	return !key.Retired_at.IsZero() && time.Since(key.Retired_at) > MaxTokenLifetime
+}
+
+// KeyRing holds the key new tokens are signed with plus every key a token may be
+// verified with. For HS256 the ring only holds the shared SECRET_KEY.
+type KeyRing struct {
+	mu      sync.RWMutex
+	method  jwt.SigningMethod
+	signing *SigningKey
+	keys    map[string]*SigningKey
+}
+
+var keyRing = mustLoadKeyRing()
+
+// the jwt-go version we use has no EdDSA support, so we register our own.
+var SigningMethodEdDSA = &signingMethodEd25519{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEd25519 struct{}
+
+func (m *signingMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *signingMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("EdDSA signature is invalid")
+	}
+	return nil
+}
+
+func mustLoadKeyRing() *KeyRing {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		log.Fatal("Error loading the signing keys: ", err)
+	}
+	return ring
+}
+
+// LoadKeyRing builds the key ring from the environment:
+//
+//	JWT_SIGNING_ALG       HS256 (default), RS256, ES256 or EdDSA
+//	JWT_KEYS_DIR          directory of PEM keys, the newest private key signs (see loadKeyDir)
+//	JWT_PRIVATE_KEY_FILE  PEM file with the key new tokens are signed with, when there is no JWT_KEYS_DIR
+//	JWT_PUBLIC_KEY_FILES  comma separated PEM files that are only used to verify
+func LoadKeyRing() (*KeyRing, error) {
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		ring.method = jwt.SigningMethodHS256
+		ring.signing = &SigningKey{Method: ring.method, PrivateKey: []byte(SECRET_KEY), PublicKey: []byte(SECRET_KEY)}
+		return ring, nil
+	case jwt.SigningMethodRS256.Alg():
+		ring.method = jwt.SigningMethodRS256
+	case jwt.SigningMethodES256.Alg():
+		ring.method = jwt.SigningMethodES256
+	case SigningMethodEdDSA.Alg():
+		ring.method = SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		if err := ring.loadKeyDir(dir); err != nil {
+			return nil, err
+		}
+	} else {
+		privateKeyFile := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if privateKeyFile == "" {
+			return nil, fmt.Errorf("JWT_KEYS_DIR or JWT_PRIVATE_KEY_FILE must be set when JWT_SIGNING_ALG is %s", alg)
+		}
+		signing, err := ring.loadKeyFile(privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if signing.PrivateKey == nil {
+			return nil, fmt.Errorf("%s does not contain a private key", privateKeyFile)
+		}
+		ring.signing = signing
+		ring.keys[signing.Kid] = signing
+	}
+
+	for _, file := range strings.Split(os.Getenv("JWT_PUBLIC_KEY_FILES"), ",") {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+		key, err := ring.loadKeyFile(file)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[key.Kid] = key
+	}
+	return ring, nil
+}
+
+// loadKeyDir loads every *.pem file of the directory, ordered by modification time.
+// The newest private key is the active one. Every key older than it was retired
+// when the key after it was added, and is kept for verification until
+// MaxTokenLifetime has passed since then.
+func (ring *KeyRing) loadKeyDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	type loadedKey struct {
+		key     *SigningKey
+		path    string
+		modTime time.Time
+	}
+	var found []loadedKey
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		key, err := ring.loadKeyFile(path)
+		if err != nil {
+			return err
+		}
+		found = append(found, loadedKey{key: key, path: path, modTime: info.ModTime()})
+	}
+	// keys written in the same instant are ordered by their name, rotated keys
+	// are named after the time they were made
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].modTime.Equal(found[j].modTime) {
+			return found[i].path < found[j].path
+		}
+		return found[i].modTime.Before(found[j].modTime)
+	})
+
+	active := -1
+	for i := len(found) - 1; i >= 0; i-- {
+		if found[i].key.PrivateKey != nil {
+			active = i
+			break
+		}
+	}
+	if active < 0 {
+		return fmt.Errorf("%s does not contain a private key", dir)
+	}
+	ring.signing = found[active].key
+
+	for i, entry := range found {
+		if i < active {
+			entry.key.Retired_at = found[i+1].modTime
+			if entry.key.expired() {
+				log.Printf("Skipping signing key %s, it was retired on %s", entry.key.Kid, entry.key.Retired_at.Format(time.RFC3339))
+				continue
+			}
+		}
+		ring.keys[entry.key.Kid] = entry.key
+	}
+	return nil
+}
+
+// ReloadKeyRing reads the keys from disk again and swaps them in, so keys can be
+// rotated without a restart. Tokens keep verifying while the swap happens.
+func ReloadKeyRing() error {
+	ring, err := LoadKeyRing()
+	if err != nil {
+		return err
+	}
+
+	keyRing.mu.Lock()
+	defer keyRing.mu.Unlock()
+	keyRing.method = ring.method
+	keyRing.signing = ring.signing
+	keyRing.keys = ring.keys
+	log.Printf("Signing keys reloaded, active key is %s", ring.signing.Kid)
+	return nil
+}
+
+// RotateSigningKey generates a new key for the configured algorithm, writes it to
+// JWT_KEYS_DIR and makes it the active one. The previous key is retired and keeps
+// verifying the tokens it signed. It returns the kid of the new key.
+// HS256 has a single shared secret and no kid, it can't be rotated this way.
+func RotateSigningKey() (string, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		return "", errors.New("key rotation needs JWT_KEYS_DIR to be set")
+	}
+
+	var privateKey interface{}
+	var err error
+	switch SigningAlgorithm() {
+	case jwt.SigningMethodRS256.Alg():
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SigningMethodEdDSA.Alg():
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", fmt.Errorf("keys can't be rotated for %s", SigningAlgorithm())
+	}
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// write to a temp file first, a half written key would break the next reload.
+	// The name is in nanoseconds and linked in without replacing anything, two
+	// rotations close together must never overwrite the key that is signing.
+	name := fmt.Sprintf("%d.pem", time.Now().UnixNano())
+	tmp, err := os.CreateTemp(dir, ".key-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Link(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return "", err
+	}
+
+	if err := ReloadKeyRing(); err != nil {
+		return "", err
+	}
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return keyRing.signing.Kid, nil
+}
+
+func (ring *KeyRing) loadKeyFile(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	key := &SigningKey{Method: ring.method}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.PrivateKey = parsed
+		parsed = signer.Public()
+	}
+	key.PublicKey = parsed
+
+	// make sure the key actually fits the configured algorithm
+	switch publicKey := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if ring.method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("%s: RSA key can't be used with %s", path, ring.method.Alg())
+		}
+	case *ecdsa.PublicKey:
+		if ring.method != jwt.SigningMethodES256 || publicKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s: EC key must be P-256 and used with ES256", path)
+		}
+	case ed25519.PublicKey:
+		if ring.method != SigningMethodEdDSA {
+			return nil, fmt.Errorf("%s: Ed25519 key can't be used with %s", path, ring.method.Alg())
+		}
+		// ParsePKCS8PrivateKey hands out ed25519.PrivateKey by value, which is what
+		// our signing method expects, nothing to convert here
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, key.PublicKey)
+	}
+
+	jwk, err := publicJWK(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	key.Kid = jwkThumbprint(jwk)
+	return key, nil
+}
+
+// signClaims signs the claims with the active key of the ring.
+func signClaims(claims jwt.Claims) (string, error) {
+	keyRing.mu.RLock()
+	signing := keyRing.signing
+	keyRing.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signing.Method, claims)
+	if signing.Kid != "" {
+		token.Header["kid"] = signing.Kid
+	}
+	return token.SignedString(signing.PrivateKey)
+}
+
+// verificationKey is the jwt.Keyfunc used by every token parse. It only accepts the
+// configured algorithm, so an RS256 public key can never be abused as an HS256 secret.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	if token.Method.Alg() != keyRing.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	if kid, ok := token.Header["kid"].(string); ok && kid != "" {
+		key, found := keyRing.keys[kid]
+		if !found {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if key.expired() {
+			return nil, fmt.Errorf("signing key %q has been retired", kid)
+		}
+		return key.PublicKey, nil
+	}
+	return keyRing.signing.PublicKey, nil
+}
+
+// JWKS returns the public keys of the ring as a JSON Web Key Set. HS256 keys are
+// never published, so the set is empty in that case.
+func JWKS() map[string]interface{} {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+
+	keys := []map[string]string{}
+	for _, key := range keyRing.keys {
+		if key.expired() {
+			continue
+		}
+		jwk, err := publicJWK(key)
+		if err != nil {
+			continue
+		}
+		jwk["kid"] = key.Kid
+		jwk["use"] = "sig"
+		jwk["alg"] = key.Method.Alg()
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+// SigningAlgorithm is the alg new tokens are signed with.
+func SigningAlgorithm() string {
+	keyRing.mu.RLock()
+	defer keyRing.mu.RUnlock()
+	return keyRing.method.Alg()
+}
+
+func publicJWK(key *SigningKey) (map[string]string, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch publicKey := key.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{
+			"kty": "RSA",
+			"n":   encode(publicKey.N.Bytes()),
+			"e":   encode(big.NewInt(int64(publicKey.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		return map[string]string{
+			"kty": "EC",
+			"crv": publicKey.Curve.Params().Name,
+			"x":   encode(publicKey.X.FillBytes(make([]byte, size))),
+			"y":   encode(publicKey.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]string{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   encode(publicKey),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key.PublicKey)
+}
+
+// jwkThumbprint is the RFC 7638 thumbprint of the key, we use it as the kid.
+func jwkThumbprint(jwk map[string]string) string {
+	var members []string
+	switch jwk["kty"] {
+	case "RSA":
+		members = []string{"e", "kty", "n"}
+	case "EC":
+		members = []string{"crv", "kty", "x", "y"}
+	case "OKP":
+		members = []string{"crv", "kty", "x"}
+	}
+	// the thumbprint input has to be the required members in lexical order
+	// without whitespace, which is why this is not a plain json.Marshal of the map
+	parts := make([]string, 0, len(members))
+	for _, name := range members {
+		value, _ := json.Marshal(jwk[name])
+		parts = append(parts, fmt.Sprintf("%q:%s", name, value))
+	}
+	sum := sha256.Sum256([]byte("{" + strings.Join(parts, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Failed logins of an email slow down further tries exponentially (LOGIN_BACKOFF_BASE,
+// doubled with every failure), and after LOGIN_MAX_FAILURES the email is locked for
+// LOGIN_LOCK_DURATION. Failures older than the lock duration are forgotten.
+var (
+	loginMaxFailures  = EnvInt("LOGIN_MAX_FAILURES", 5)
+	loginBackoffBase  = EnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+	loginLockDuration = EnvDuration("LOGIN_LOCK_DURATION", 15*time.Minute)
+)
+
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// LoginRetryAfter is how long the email has to wait before the next password login,
+// zero if it may try right away.
+func LoginRetryAfter(email string) time.Duration {
+	var attempt models.LoginAttempt
+	if err := userDB.Where("email = ?", normalizeLoginEmail(email)).First(&attempt).Error; err != nil {
+		return 0
+	}
+
+	now := time.Now()
+	if attempt.Locked_until != nil && attempt.Locked_until.After(now) {
+		return attempt.Locked_until.Sub(now)
+	}
+	if attempt.Failed_count == 0 || attempt.Failed_count >= loginMaxFailures {
+		// a lock that ran out, the next failure starts counting from scratch
+		return 0
+	}
+	if wait := attempt.Last_failed_at.Add(loginBackoff(attempt.Failed_count)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func loginBackoff(failures int) time.Duration {
+	backoff := loginBackoffBase
+	for i := 1; i < failures && backoff < loginLockDuration; i++ {
+		backoff *= 2
+	}
+	if backoff > loginLockDuration {
+		return loginLockDuration
+	}
+	return backoff
+}
+
+// RecordLoginFailure counts a failed password login and locks the email once it
+// reaches loginMaxFailures.
+func RecordLoginFailure(email string) error {
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:          normalizeLoginEmail(email),
+		Failed_count:   1,
+		Last_failed_at: now,
+	}
+	// counting happens in the database, parallel tries can't get past the limit
+	err := userDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "email"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "failed_count"}, Value: gorm.Expr(
+				"CASE WHEN login_attempts.last_failed_at < ? OR login_attempts.locked_until < ? THEN 1 ELSE login_attempts.failed_count + 1 END",
+				now.Add(-loginLockDuration), now,
+			)},
+			{Column: clause.Column{Name: "locked_until"}, Value: gorm.Expr(
+				"CASE WHEN login_attempts.locked_until < ? THEN NULL ELSE login_attempts.locked_until END", now,
+			)},
+			{Column: clause.Column{Name: "last_failed_at"}, Value: gorm.Expr("excluded.last_failed_at")},
+		},
+	}).Create(&attempt).Error
+	if err != nil {
+		return err
+	}
+
+	return userDB.Model(&models.LoginAttempt{}).
+		Where("email = ? AND failed_count >= ? AND locked_until IS NULL", attempt.Email, loginMaxFailures).
+		Update("locked_until", now.Add(loginLockDuration)).Error
+}
+
+// ResetLoginFailures forgets the failed logins of the email, after a successful
+// login or when an admin unlocks the account.
+func ResetLoginFailures(email string) error {
+	return userDB.Where("email = ?", normalizeLoginEmail(email)).Delete(&models.LoginAttempt{}).Error
+}
@@ -0,0 +1,65 @@
+package helpers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+)
+
+// the defaults: LOGIN_BACKOFF_BASE 1s, LOGIN_MAX_FAILURES 5, LOGIN_LOCK_DURATION 15m
+func TestLoginBackoffGrowsLocksAndResets(t *testing.T) {
+	db := databasetest.Setup(t)
+	email := "ada@example.com"
+	if wait := helper.LoginRetryAfter(email); wait != 0 {
+		t.Fatalf("no failures yet, want no wait, got %v", wait)
+	}
+
+	for failures, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if err := helper.RecordLoginFailure(email); err != nil {
+			t.Fatal(err)
+		}
+		if wait := helper.LoginRetryAfter(email); wait <= want/2 || wait > want {
+			t.Fatalf("after %d failures want a wait of about %v, got %v", failures+1, want, wait)
+		}
+	}
+	// the email counts, not how it was typed
+	if err := helper.RecordLoginFailure(" ADA@example.com "); err != nil {
+		t.Fatal(err)
+	}
+	if wait := helper.LoginRetryAfter(email); wait <= 14*time.Minute || wait > 15*time.Minute {
+		t.Fatalf("after 5 failures want the 15m lock, got %v", wait)
+	}
+
+	// a successful login forgets everything
+	if err := helper.ResetLoginFailures(email); err != nil {
+		t.Fatal(err)
+	}
+	if wait := helper.LoginRetryAfter(email); wait != 0 {
+		t.Fatalf("after a reset want no wait, got %v", wait)
+	}
+
+	// so does a lock that ran out, the next failure starts from the base again
+	for i := 0; i < 5; i++ {
+		if err := helper.RecordLoginFailure(email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-time.Minute)
+	err := db.Model(&models.LoginAttempt{}).Where("email = ?", email).
+		Updates(map[string]interface{}{"last_failed_at": past.Add(-15 * time.Minute), "locked_until": past}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := helper.LoginRetryAfter(email); wait != 0 {
+		t.Fatalf("the lock ran out, want no wait, got %v", wait)
+	}
+	if err := helper.RecordLoginFailure(email); err != nil {
+		t.Fatal(err)
+	}
+	if wait := helper.LoginRetryAfter(email); wait <= time.Second/2 || wait > time.Second {
+		t.Fatalf("the first failure after the lock, want the base wait, got %v", wait)
+	}
+}
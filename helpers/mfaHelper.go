@@ -0,0 +1,80 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// GenerateRecoveryCodes replaces the recovery codes of the user with a fresh set and
+// returns them. This is the only time the codes are ever readable.
+func GenerateRecoveryCodes(userId string) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	now := time.Now()
+	for i := 0; i < recoveryCodeCount; i++ {
+		random := make([]byte, 5)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		// 8 base32 chars split in two, easy to read out and type
+		code := strings.ToLower(base32NoPadding.EncodeToString(random))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{
+			User_id:    userId,
+			Code_hash:  HashToken(normalizeRecoveryCode(code)),
+			Created_at: now,
+		})
+	}
+
+	err := userDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode burns one recovery code of the user, it reports false when the
+// code is unknown or was used already.
+func UseRecoveryCode(userId string, code string) bool {
+	result := userDB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// VerifyUserTOTP checks a TOTP code of a user with MFA (or a pending enrollment)
+// and records the step, so the same code can't be used twice.
+func VerifyUserTOTP(user models.User, code string) bool {
+	if user.Mfa_secret == nil {
+		return false
+	}
+	secret, err := DecryptSecret(*user.Mfa_secret)
+	if err != nil {
+		return false
+	}
+	step, ok := ValidateTOTP(secret, code, user.Mfa_last_step)
+	if !ok {
+		return false
+	}
+	// compare-and-swap, two requests with the same code can't both get through
+	result := userDB.Model(&models.User{}).
+		Where("user_id = ? AND mfa_last_step < ?", user.User_id, step).
+		Update("mfa_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+)
+
+var ErrInvalidOAuthCode = errors.New("the code is invalid, expired or was issued to another browser")
+
+// OAuthCodeTTL is how long the frontend has to exchange the code, it only has to
+// survive one redirect.
+var OAuthCodeTTL = EnvDuration("OAUTH_CODE_TTL", time.Minute)
+
+// OAuthLogin is what a login code stands for, the identity the provider vouched
+// for plus extras for the token response (like the picture).
+type OAuthLogin struct {
+	Identity ExternalIdentity
+	Extra    map[string]string
+}
+
+// RandomToken returns n random bytes, base64url encoded.
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// IssueOAuthLoginCode stores a finished provider login under a new single-use code
+// that only the holder of binding (a secret kept in the browser session) can redeem.
+func IssueOAuthLoginCode(login OAuthLogin, binding string) (string, error) {
+	data, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+	code, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	// codes nobody came back for pile up, drop them on the way
+	userDB.Where("expires_at < ?", now).Delete(&models.OAuthLoginCode{})
+
+	row := models.OAuthLoginCode{
+		Code_hash:    HashToken(code),
+		Binding_hash: HashToken(binding),
+		Data:         string(data),
+		Expires_at:   now.Add(OAuthCodeTTL),
+		Created_at:   now,
+	}
+	if err := userDB.Create(&row).Error; err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RedeemOAuthLoginCode uses up the code and returns the login it stands for.
+func RedeemOAuthLoginCode(code string, binding string) (OAuthLogin, error) {
+	var login OAuthLogin
+	if code == "" || binding == "" {
+		return login, ErrInvalidOAuthCode
+	}
+
+	var row models.OAuthLoginCode
+	err := userDB.Where("code_hash = ? AND binding_hash = ? AND used_at IS NULL AND expires_at > ?", HashToken(code), HashToken(binding), time.Now()).
+		First(&row).Error
+	if err != nil {
+		return login, ErrInvalidOAuthCode
+	}
+	result := userDB.Model(&models.OAuthLoginCode{}).
+		Where("id = ? AND used_at IS NULL", row.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return login, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return login, ErrInvalidOAuthCode
+	}
+
+	err = json.Unmarshal([]byte(row.Data), &login)
+	return login, err
+}
@@ -0,0 +1,238 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
+	"github.com/markbates/goth/providers/gitlab"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/microsoftonline"
+	"github.com/markbates/goth/providers/openidConnect"
+)
+
+var ErrUnknownOAuthProvider = errors.New("unknown login provider")
+
+// ClaimMapping names the fields of the provider's user info (goth's RawData) our
+// user fields come from. An empty name takes what goth already mapped.
+type ClaimMapping struct {
+	Subject        string
+	Email          string
+	Email_verified string
+	First_name     string
+	Last_name      string
+	Picture        string
+}
+
+// OAuthProviderConfig is one social login provider, see LoadOAuthProviders.
+type OAuthProviderConfig struct {
+	Name          string // in the routes, /auth/<name>
+	Type          string // google, github, microsoft, gitlab or oidc
+	Client_id     string
+	Client_secret string
+	Callback_url  string
+	Scopes        []string
+	Issuer_url    string // oidc only, discovery happens at <issuer>/.well-known/openid-configuration
+	Base_url      string // gitlab only, for self-hosted instances
+	Claims        ClaimMapping
+	Trust_email   bool // treat every email of the provider as verified
+}
+
+// defaults per provider type
+var oauthProviderDefaults = map[string]OAuthProviderConfig{
+	"google":    {Scopes: []string{"email", "profile"}, Claims: ClaimMapping{Email_verified: "verified_email"}},
+	"github":    {Scopes: []string{"read:user", "user:email"}, Trust_email: true}, // goth only returns verified emails
+	"microsoft": {Scopes: []string{"openid", "offline_access", "user.read"}},
+	"gitlab":    {Scopes: []string{"read_user"}},
+	"oidc":      {Scopes: []string{"openid", "email", "profile"}, Claims: ClaimMapping{Email_verified: "email_verified"}},
+}
+
+var oauthProviders = map[string]OAuthProviderConfig{}
+
+// BackendURL is where this server is reachable from browsers, for the callback URLs.
+func BackendURL() string {
+	if url := os.Getenv("BACKEND_URL"); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return "http://localhost:8000"
+}
+
+// LoadOAuthProviders builds the providers listed in OAUTH_PROVIDERS (default
+// "google"). Each name is configured by environment variables prefixed with the
+// name in upper case, like GITHUB_CLIENT_ID:
+//
+//	<NAME>_TYPE           google, github, microsoft, gitlab or oidc, defaults to the name
+//	<NAME>_CLIENT_ID, <NAME>_CLIENT_SECRET
+//	<NAME>_CALLBACK_URL   defaults to BACKEND_URL/auth/<name>/callback
+//	<NAME>_SCOPES         space or comma separated, replaces the defaults of the type
+//	<NAME>_ISSUER_URL     oidc only
+//	<NAME>_BASE_URL       gitlab only, for self-hosted instances
+//	<NAME>_CLAIM_SUBJECT, _EMAIL, _EMAIL_VERIFIED, _FIRST_NAME, _LAST_NAME, _PICTURE
+//	<NAME>_TRUST_EMAIL    treat the provider's emails as verified
+//
+// Only verified emails create or link accounts. Microsoft and GitLab don't say
+// whether an email is verified, so they need _CLAIM_EMAIL_VERIFIED or _TRUST_EMAIL
+// to be set, otherwise no first login could ever succeed.
+func LoadOAuthProviders() ([]goth.Provider, error) {
+	names := os.Getenv("OAUTH_PROVIDERS")
+	if names == "" {
+		names = "google"
+	}
+
+	var providers []goth.Provider
+	for _, name := range splitList(names) {
+		name = strings.ToLower(name)
+		config, err := oauthProviderConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		provider, err := newGothProvider(config)
+		if err != nil {
+			return nil, fmt.Errorf("login provider %s: %w", name, err)
+		}
+		oauthProviders[name] = config
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+func oauthProviderConfig(name string) (OAuthProviderConfig, error) {
+	prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	env := func(key string) string { return os.Getenv(prefix + key) }
+
+	providerType := strings.ToLower(env("TYPE"))
+	if providerType == "" {
+		providerType = name
+	}
+	if providerType == "microsoftonline" {
+		providerType = "microsoft"
+	}
+	config, found := oauthProviderDefaults[providerType]
+	if !found {
+		return config, fmt.Errorf("login provider %s: unknown type %q, set %sTYPE", name, providerType, prefix)
+	}
+
+	config.Name = name
+	config.Type = providerType
+	config.Client_id = env("CLIENT_ID")
+	config.Client_secret = env("CLIENT_SECRET")
+	config.Callback_url = env("CALLBACK_URL")
+	if config.Callback_url == "" {
+		config.Callback_url = BackendURL() + "/auth/" + name + "/callback"
+	}
+	if scopes := env("SCOPES"); scopes != "" {
+		config.Scopes = splitList(scopes)
+	}
+	config.Issuer_url = strings.TrimRight(env("ISSUER_URL"), "/")
+	config.Base_url = strings.TrimRight(env("BASE_URL"), "/")
+	if EnvBool(prefix + "TRUST_EMAIL") {
+		config.Trust_email = true
+	}
+
+	claims := map[string]*string{
+		"SUBJECT":        &config.Claims.Subject,
+		"EMAIL":          &config.Claims.Email,
+		"EMAIL_VERIFIED": &config.Claims.Email_verified,
+		"FIRST_NAME":     &config.Claims.First_name,
+		"LAST_NAME":      &config.Claims.Last_name,
+		"PICTURE":        &config.Claims.Picture,
+	}
+	for key, field := range claims {
+		if value := env("CLAIM_" + key); value != "" {
+			*field = value
+		}
+	}
+
+	if config.Client_id == "" || config.Client_secret == "" {
+		return config, fmt.Errorf("%sCLIENT_ID and %sCLIENT_SECRET must be set", prefix, prefix)
+	}
+	if config.Type == "oidc" && config.Issuer_url == "" {
+		return config, fmt.Errorf("%sISSUER_URL must be set", prefix)
+	}
+	if !config.Trust_email && config.Claims.Email_verified == "" {
+		return config, fmt.Errorf("login provider %s doesn't tell verified emails apart, set %sCLAIM_EMAIL_VERIFIED or %sTRUST_EMAIL", name, prefix, prefix)
+	}
+	return config, nil
+}
+
+func newGothProvider(config OAuthProviderConfig) (goth.Provider, error) {
+	switch config.Type {
+	case "google":
+		provider := google.New(config.Client_id, config.Client_secret, config.Callback_url, config.Scopes...)
+		provider.SetName(config.Name)
+		return provider, nil
+	case "github":
+		provider := github.New(config.Client_id, config.Client_secret, config.Callback_url, config.Scopes...)
+		provider.SetName(config.Name)
+		return provider, nil
+	case "microsoft":
+		provider := microsoftonline.New(config.Client_id, config.Client_secret, config.Callback_url, config.Scopes...)
+		provider.SetName(config.Name)
+		return provider, nil
+	case "gitlab":
+		provider := gitlab.New(config.Client_id, config.Client_secret, config.Callback_url, config.Scopes...)
+		if config.Base_url != "" {
+			provider = gitlab.NewCustomisedURL(config.Client_id, config.Client_secret, config.Callback_url,
+				config.Base_url+"/oauth/authorize", config.Base_url+"/oauth/token", config.Base_url+"/api/v4/user",
+				config.Scopes...)
+		}
+		provider.SetName(config.Name)
+		return provider, nil
+	case "oidc":
+		return openidConnect.NewNamed(config.Name, config.Client_id, config.Client_secret, config.Callback_url,
+			config.Issuer_url+"/.well-known/openid-configuration", config.Scopes...)
+	}
+	return nil, fmt.Errorf("unknown type %q", config.Type)
+}
+
+// OAuthProviderEnabled reports whether name is one of the configured providers.
+func OAuthProviderEnabled(name string) bool {
+	_, found := oauthProviders[name]
+	return found
+}
+
+// MapOAuthUser turns what a provider returned into the login we act on, following
+// the claim mapping of the provider.
+func MapOAuthUser(name string, user goth.User) (OAuthLogin, error) {
+	config, found := oauthProviders[name]
+	if !found {
+		return OAuthLogin{}, ErrUnknownOAuthProvider
+	}
+	claim := func(key string, fallback string) string {
+		if key == "" {
+			return fallback
+		}
+		if value, ok := user.RawData[key]; ok && value != nil {
+			return fmt.Sprint(value)
+		}
+		return ""
+	}
+
+	identity := ExternalIdentity{
+		Provider:   config.Name,
+		Subject:    claim(config.Claims.Subject, user.UserID),
+		Email:      strings.TrimSpace(claim(config.Claims.Email, user.Email)),
+		First_name: claim(config.Claims.First_name, user.FirstName),
+		Last_name:  claim(config.Claims.Last_name, user.LastName),
+	}
+	if identity.First_name == "" && identity.Last_name == "" {
+		identity.First_name = user.Name
+	}
+	identity.Email_verified = config.Trust_email ||
+		(config.Claims.Email_verified != "" && strings.EqualFold(claim(config.Claims.Email_verified, ""), "true"))
+
+	return OAuthLogin{
+		Identity: identity,
+		Extra:    map[string]string{"picture": claim(config.Claims.Picture, user.AvatarURL)},
+	}, nil
+}
+
+// splitList splits a space or comma separated list.
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
@@ -0,0 +1,21 @@
+package helpers_test
+
+import (
+	"testing"
+
+	helper "github.com/Aaryansingh20/jwt/helpers"
+)
+
+func TestProvidersWithoutEmailVerificationAreRefused(t *testing.T) {
+	t.Setenv("OAUTH_PROVIDERS", "gitlab")
+	t.Setenv("GITLAB_CLIENT_ID", "id")
+	t.Setenv("GITLAB_CLIENT_SECRET", "secret")
+	if _, err := helper.LoadOAuthProviders(); err == nil {
+		t.Fatal("gitlab was registered without a way to tell verified emails")
+	}
+
+	t.Setenv("GITLAB_TRUST_EMAIL", "true")
+	if _, err := helper.LoadOAuthProviders(); err != nil {
+		t.Fatalf("with GITLAB_TRUST_EMAIL: %v", err)
+	}
+}
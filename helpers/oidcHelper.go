@@ -0,0 +1,449 @@
+package helpers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+)
+
+// IDTokenType is the Token_type of ID tokens. They are only for the client to read,
+// ValidateToken turns them down like every other non-access token.
+const IDTokenType = "id"
+
+// the scopes clients can ask for
+var SupportedOIDCScopes = []string{"openid", "profile", "email", "phone", "offline_access"}
+
+var (
+	ErrInvalidClient = errors.New("invalid_client")
+	ErrInvalidGrant  = errors.New("invalid_grant")
+)
+
+// how long an authorization code can wait to be exchanged
+var authorizationCodeTTL = EnvDuration("OIDC_CODE_TTL", time.Minute)
+
+// OIDCIssuer is our issuer identifier, OIDC_ISSUER or BACKEND_URL.
+func OIDCIssuer() string {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		return strings.TrimRight(issuer, "/")
+	}
+	return BackendURL()
+}
+
+// OIDCEnabled reports whether clients can verify our ID tokens, they need a public
+// key from the JWKS. With HS256 only we could.
+func OIDCEnabled() bool {
+	return SigningAlgorithm() != "HS256"
+}
+
+// ParseScope splits a scope parameter, dropping duplicates.
+func ParseScope(scope string) []string {
+	seen := map[string]bool{}
+	var scopes []string
+	for _, s := range strings.Fields(scope) {
+		if !seen[s] {
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// ScopeIncludes reports whether the space separated scope contains want.
+func ScopeIncludes(scope string, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeCovers reports whether granted contains every scope of requested.
+func ScopeCovers(granted string, requested string) bool {
+	for _, s := range strings.Fields(requested) {
+		if !ScopeIncludes(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// RegisterOAuthClient creates a client. Confidential clients get a secret, it is
+// returned once and only its hash is kept.
+func RegisterOAuthClient(name string, redirectURIs []string, scopes []string, grantTypes []string, confidential bool, skipConsent bool) (models.OAuthClient, string, error) {
+	now := time.Now()
+	client := models.OAuthClient{
+		Client_id:     uuid.New().String(),
+		Name:          name,
+		Redirect_uris: redirectURIs,
+		Scopes:        strings.Join(scopes, " "),
+		Grant_types:   strings.Join(grantTypes, " "),
+		Skip_consent:  skipConsent,
+		Created_at:    now,
+		Updated_at:    now,
+	}
+	var secret string
+	if confidential {
+		var err error
+		if secret, err = RandomToken(32); err != nil {
+			return client, "", err
+		}
+		secretHash := HashToken(secret)
+		client.Client_secret_hash = &secretHash
+	}
+	err := userDB.Create(&client).Error
+	return client, secret, err
+}
+
+// FindOAuthClient looks a client up by its id.
+func FindOAuthClient(clientID string) (models.OAuthClient, error) {
+	var client models.OAuthClient
+	if clientID == "" {
+		return client, ErrInvalidClient
+	}
+	if err := userDB.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return client, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// AuthenticateOAuthClient checks the credentials a client sent to the token endpoint.
+// Public clients send no secret, confidential ones must send theirs.
+func AuthenticateOAuthClient(clientID string, secret string) (models.OAuthClient, error) {
+	client, err := FindOAuthClient(clientID)
+	if err != nil {
+		return client, err
+	}
+	if !client.Confidential() {
+		if secret != "" {
+			return client, ErrInvalidClient
+		}
+		return client, nil
+	}
+	if secret == "" || !hmac.Equal([]byte(HashToken(secret)), []byte(*client.Client_secret_hash)) {
+		return client, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// ClientAllowsRedirect reports whether uri is one of the registered redirect URIs,
+// compared exactly like the spec asks.
+func ClientAllowsRedirect(client models.OAuthClient, uri string) bool {
+	for _, allowed := range client.Redirect_uris {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantableScope keeps the requested scopes the client may ask for, in a stable order.
+func GrantableScope(client models.OAuthClient, requested string) string {
+	var granted []string
+	for _, s := range ParseScope(requested) {
+		if ScopeIncludes(client.Scopes, s) {
+			granted = append(granted, s)
+		}
+	}
+	sort.Strings(granted)
+	return strings.Join(granted, " ")
+}
+
+// HasConsent reports whether the user already agreed to share scope with the client.
+func HasConsent(userId string, clientID string, scope string) bool {
+	var consent models.OAuthConsent
+	if err := userDB.Where("user_id = ? AND client_id = ?", userId, clientID).First(&consent).Error; err != nil {
+		return false
+	}
+	return ScopeCovers(consent.Scope, scope)
+}
+
+// SaveConsent remembers what the user agreed to, on top of what they agreed to before.
+func SaveConsent(userId string, clientID string, scope string) error {
+	var existing models.OAuthConsent
+	if err := userDB.Where("user_id = ? AND client_id = ?", userId, clientID).First(&existing).Error; err == nil {
+		scope = strings.Join(ParseScope(existing.Scope+" "+scope), " ")
+	}
+	now := time.Now()
+	consent := models.OAuthConsent{
+		User_id:    userId,
+		Client_id:  clientID,
+		Scope:      scope,
+		Created_at: now,
+		Updated_at: now,
+	}
+	return userDB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"scope", "updated_at"}),
+	}).Create(&consent).Error
+}
+
+// StartOIDCLogin stores a login of the user at /authorize that lasts for ttl and
+// returns the handle the browser keeps in its cookie. Only the hash of the handle
+// is stored, like the tokens.
+func StartOIDCLogin(userId string, authTime time.Time, ttl time.Duration) (string, error) {
+	handle, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	// logins nobody came back to pile up, drop them on the way
+	userDB.Where("expires_at < ?", authTime).Delete(&models.OIDCLogin{})
+
+	login := models.OIDCLogin{
+		Handle_hash: HashToken(handle),
+		User_id:     userId,
+		Auth_us:     authTime.UnixMicro(),
+		Expires_at:  authTime.Add(ttl),
+		Created_at:  authTime,
+	}
+	if err := userDB.Create(&login).Error; err != nil {
+		return "", err
+	}
+	return handle, nil
+}
+
+// FindOIDCLogin returns the login the handle of a cookie stands for, unless it ran out.
+func FindOIDCLogin(handle string) (models.OIDCLogin, bool) {
+	var login models.OIDCLogin
+	if handle == "" {
+		return login, false
+	}
+	err := userDB.Where("handle_hash = ? AND expires_at > ?", HashToken(handle), time.Now()).First(&login).Error
+	return login, err == nil
+}
+
+// EndOIDCLogin logs the browser with the handle out of /authorize.
+func EndOIDCLogin(handle string) error {
+	if handle == "" {
+		return nil
+	}
+	return userDB.Where("handle_hash = ?", HashToken(handle)).Delete(&models.OIDCLogin{}).Error
+}
+
+// IssueAuthorizationCode stores a code for the client to exchange at the token endpoint.
+func IssueAuthorizationCode(code models.OAuthAuthorizationCode) (string, error) {
+	value, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	// codes nobody came back for pile up, drop them on the way
+	userDB.Where("expires_at < ?", now).Delete(&models.OAuthAuthorizationCode{})
+
+	code.Code_hash = HashToken(value)
+	code.Expires_at = now.Add(authorizationCodeTTL)
+	code.Created_at = now
+	if err := userDB.Create(&code).Error; err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// RedeemAuthorizationCode uses up the code, checking it was issued to the client for
+// the same redirect URI, and that the PKCE verifier matches the challenge. The code
+// gets the id of the session to create for it in Session_id, so a code that is
+// presented again can take down what was issued for it (RFC 6749, section 4.1.2).
+func RedeemAuthorizationCode(value string, client models.OAuthClient, redirectURI string, verifier string) (models.OAuthAuthorizationCode, error) {
+	var code models.OAuthAuthorizationCode
+	err := userDB.Where("code_hash = ?", HashToken(value)).First(&code).Error
+	if err != nil {
+		return code, ErrInvalidGrant
+	}
+	if code.Used_at != nil {
+		// somebody else has the code too, nobody can tell which one is the client
+		revokeCodeSession(code)
+		return code, ErrInvalidGrant
+	}
+	if !code.Expires_at.After(time.Now()) {
+		return code, ErrInvalidGrant
+	}
+	if code.Client_id != client.Client_id || code.Redirect_uri != redirectURI {
+		return code, ErrInvalidGrant
+	}
+	if code.Code_challenge != "" || verifier != "" {
+		if !hmac.Equal([]byte(PKCEChallenge(verifier)), []byte(code.Code_challenge)) {
+			return code, ErrInvalidGrant
+		}
+	}
+
+	sessionID := uuid.New().String()
+	result := userDB.Model(&models.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", code.ID).
+		Updates(map[string]interface{}{
+			"used_at":    time.Now(),
+			"session_id": sessionID,
+		})
+	if result.Error != nil {
+		return code, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return code, ErrInvalidGrant
+	}
+	code.Session_id = sessionID
+	return code, nil
+}
+
+// revokeCodeSession ends the session a replayed code was redeemed for. The denylist
+// entry is made even when the session isn't there (yet), its tokens carry the id.
+func revokeCodeSession(code models.OAuthAuthorizationCode) {
+	if code.Session_id == "" {
+		return
+	}
+	log.Printf("Authorization code of client %s was used twice, revoking session %s", code.Client_id, code.Session_id)
+	if err := RevokeSession(code.User_id, code.Session_id); err != nil {
+		log.Println("Error revoking session:", err)
+	}
+	if err := RevokeToken(code.Session_id, code.User_id, time.Now().Add(MaxTokenLifetime)); err != nil {
+		log.Println("Error revoking session:", err)
+	}
+}
+
+// PKCEChallenge is the S256 challenge of a verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// IDTokenClaims are the claims of our ID tokens: the user under the names OIDC
+// clients look for, plus what the login itself was like.
+type IDTokenClaims struct {
+	Token_type     string `json:"Token_type"` // keeps ID tokens from passing as access tokens
+	Email          string `json:"email,omitempty"`
+	Email_verified *bool  `json:"email_verified,omitempty"`
+	Name           string `json:"name,omitempty"`
+	Given_name     string `json:"given_name,omitempty"`
+	Family_name    string `json:"family_name,omitempty"`
+	User_type      string `json:"user_type,omitempty"`
+	Nonce          string `json:"nonce,omitempty"`
+	Auth_time      int64  `json:"auth_time"`
+	Azp            string `json:"azp"`
+	jwt.StandardClaims
+}
+
+// GenerateIDToken mints the ID token of a login of user to client, with the claims
+// the granted scope allows.
+func GenerateIDToken(user models.User, clientID string, scope string, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Token_type: IDTokenType,
+		Nonce:      nonce,
+		Auth_time:  authTime.Unix(),
+		Azp:        clientID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Issuer:    OIDCIssuer(),
+			Subject:   user.User_id,
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+	}
+	if ScopeIncludes(scope, "email") {
+		claims.Email = *user.Email
+		claims.Email_verified = &user.Email_verified
+	}
+	if ScopeIncludes(scope, "profile") {
+		claims.Given_name = *user.First_name
+		claims.Family_name = *user.Last_name
+		claims.Name = strings.TrimSpace(*user.First_name + " " + *user.Last_name)
+		claims.User_type = *user.User_type
+	}
+	return signClaims(&claims)
+}
+
+// UserInfo is the userinfo response for the scope of the access token.
+func UserInfo(user models.User, scope string) map[string]interface{} {
+	info := map[string]interface{}{"sub": user.User_id}
+	if ScopeIncludes(scope, "profile") {
+		info["given_name"] = *user.First_name
+		info["family_name"] = *user.Last_name
+		info["name"] = strings.TrimSpace(*user.First_name + " " + *user.Last_name)
+		info["user_type"] = *user.User_type
+		info["updated_at"] = user.Updated_at.Unix()
+	}
+	if ScopeIncludes(scope, "email") {
+		info["email"] = *user.Email
+		info["email_verified"] = user.Email_verified
+	}
+	if ScopeIncludes(scope, "phone") && user.Phone != nil && *user.Phone != "" {
+		info["phone_number"] = *user.Phone
+	}
+	return info
+}
+
+// OpenIDConfiguration is our discovery document.
+func OpenIDConfiguration() map[string]interface{} {
+	issuer := OIDCIssuer()
+	return map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/authorize",
+		"token_endpoint":                        issuer + "/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"end_session_endpoint":                  issuer + "/logout",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"scopes_supported":                      SupportedOIDCScopes,
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{SigningAlgorithm()},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "azp",
+			"email", "email_verified", "name", "given_name", "family_name", "phone_number", "user_type",
+		},
+	}
+}
+
+// ListOAuthClients returns every registered client, oldest first.
+func ListOAuthClients() ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := userDB.Order("created_at").Find(&clients).Error
+	return clients, err
+}
+
+// DeleteOAuthClient removes a client with its codes and consents, and ends the
+// sessions it was granted so its tokens stop working.
+func DeleteOAuthClient(clientID string) error {
+	client, err := FindOAuthClient(clientID)
+	if err != nil {
+		return err
+	}
+
+	// the client_credentials tokens of the client share its id as Family_id
+	if client.AllowsGrant("client_credentials") {
+		if err := RevokeToken(client.Client_id, "", time.Now().Add(ClientTokenLifetime)); err != nil {
+			return err
+		}
+	}
+
+	var sessions []models.Session
+	if err := userDB.Where("client_id = ? AND revoked_at IS NULL", client.Client_id).Find(&sessions).Error; err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := RevokeSession(session.User_id, session.Session_id); err != nil {
+			return err
+		}
+	}
+
+	if err := userDB.Where("client_id = ?", client.Client_id).Delete(&models.OAuthAuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := userDB.Where("client_id = ?", client.Client_id).Delete(&models.OAuthConsent{}).Error; err != nil {
+		return err
+	}
+	return userDB.Delete(&client).Error
+}
@@ -0,0 +1,84 @@
+package helpers_test
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestReplayedAuthorizationCodeRevokesItsSession(t *testing.T) {
+	databasetest.Setup(t)
+	email, name, userType := "ada@example.com", "Ada", "USER"
+	user := models.User{User_id: "user-1", Email: &email, First_name: &name, Last_name: &name, User_type: &userType}
+	client := models.OAuthClient{Client_id: "app", Name: "App"}
+
+	value, err := helper.IssueAuthorizationCode(models.OAuthAuthorizationCode{
+		Client_id:    client.Client_id,
+		User_id:      user.User_id,
+		Redirect_uri: "https://app.example.com/callback",
+		Scope:        "openid",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := helper.RedeemAuthorizationCode(value, client, "https://app.example.com/callback", "")
+	if err != nil || code.Session_id == "" {
+		t.Fatalf("redeem: %v, session %q", err, code.Session_id)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/token", nil)
+	token, _, err := helper.CreateClientSession(c, user, client, code.Scope, code.Session_id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, msg := helper.ValidateToken(token); msg != "" {
+		t.Fatalf("the new access token is refused: %s", msg)
+	}
+
+	if _, err := helper.RedeemAuthorizationCode(value, client, "https://app.example.com/callback", ""); !errors.Is(err, helper.ErrInvalidGrant) {
+		t.Fatalf("want ErrInvalidGrant for a used code, got %v", err)
+	}
+	if _, msg := helper.ValidateToken(token); msg == "" {
+		t.Fatal("the token of the replayed code still works")
+	}
+}
+
+func TestOIDCLoginsAreOnlyFoundByTheirHandle(t *testing.T) {
+	databasetest.Setup(t)
+	handle, err := helper.StartOIDCLogin("user-1", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login, found := helper.FindOIDCLogin(handle)
+	if !found || login.User_id != "user-1" {
+		t.Fatalf("the login of the handle: %v %v", login, found)
+	}
+	for _, other := range []string{"", "user-1", handle + "x"} {
+		if _, found := helper.FindOIDCLogin(other); found {
+			t.Fatalf("a login was found for %q", other)
+		}
+	}
+
+	if err := helper.EndOIDCLogin(handle); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := helper.FindOIDCLogin(handle); found {
+		t.Fatal("the login is still there after logging out")
+	}
+
+	expired, err := helper.StartOIDCLogin("user-1", time.Now().Add(-2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := helper.FindOIDCLogin(expired); found {
+		t.Fatal("an expired login was found")
+	}
+}
@@ -0,0 +1,199 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+// the two kinds of WebAuthn ceremonies we keep challenges for
+const (
+	PasskeyRegistration = "registration"
+	PasskeyLogin        = "login"
+)
+
+var (
+	ErrChallengeNotFound = errors.New("the challenge is unknown or expired")
+	ErrPasskeyNotFound   = errors.New("passkey not found")
+)
+
+// WebAuthn is the relying party config, from the environment:
+//
+//	WEBAUTHN_RP_ID       the domain passkeys are bound to, defaults to the host of FRONTEND_URL
+//	WEBAUTHN_RP_NAME     shown by the browser, defaults to MFA_ISSUER
+//	WEBAUTHN_RP_ORIGINS  comma separated origins allowed to run ceremonies, defaults to FRONTEND_URL
+var WebAuthn = func() *webauthn.WebAuthn {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		if frontend, err := url.Parse(FrontendURL()); err == nil {
+			rpID = frontend.Hostname()
+		}
+	}
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = MFA_ISSUER
+	}
+	origins := []string{FrontendURL()}
+	if value := os.Getenv("WEBAUTHN_RP_ORIGINS"); value != "" {
+		origins = strings.Split(value, ",")
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		log.Fatal("Error configuring WebAuthn: ", err)
+	}
+	return w
+}()
+
+// PasskeyUser adapts a models.User and its credentials to webauthn.User.
+// The user handle is the User_id, never the email.
+type PasskeyUser struct {
+	User        models.User
+	credentials []webauthn.Credential
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte {
+	return []byte(u.User.User_id)
+}
+
+func (u *PasskeyUser) WebAuthnName() string {
+	return *u.User.Email
+}
+
+func (u *PasskeyUser) WebAuthnDisplayName() string {
+	return strings.TrimSpace(*u.User.First_name + " " + *u.User.Last_name)
+}
+
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+// LoadPasskeyUser loads the registered credentials of the user.
+func LoadPasskeyUser(user models.User) (*PasskeyUser, error) {
+	var rows []models.PasskeyCredential
+	if err := userDB.Where("user_id = ?", user.User_id).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	passkeyUser := &PasskeyUser{User: user}
+	for _, row := range rows {
+		var credential webauthn.Credential
+		if err := json.Unmarshal([]byte(row.Data), &credential); err != nil {
+			return nil, err
+		}
+		passkeyUser.credentials = append(passkeyUser.credentials, credential)
+	}
+	return passkeyUser, nil
+}
+
+// SavePasskeyChallenge stores the session data of a ceremony that just began and
+// returns the id the client has to send back to finish it.
+func SavePasskeyChallenge(userId string, ceremony string, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	// dead challenges pile up when clients walk away, drop them on the way
+	userDB.Where("expires_at < ?", time.Now()).Delete(&models.PasskeyChallenge{})
+
+	challenge := models.PasskeyChallenge{
+		Challenge_id: uuid.New().String(),
+		User_id:      userId,
+		Ceremony:     ceremony,
+		Data:         string(data),
+		Expires_at:   session.Expires,
+	}
+	if challenge.Expires_at.IsZero() {
+		challenge.Expires_at = time.Now().Add(5 * time.Minute)
+	}
+	if err := userDB.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+	return challenge.Challenge_id, nil
+}
+
+// TakePasskeyChallenge returns the session data of a ceremony and deletes it,
+// whatever the outcome of the ceremony is.
+func TakePasskeyChallenge(challengeID string, userId string, ceremony string) (*webauthn.SessionData, error) {
+	var challenge models.PasskeyChallenge
+	err := userDB.Where("challenge_id = ? AND user_id = ? AND ceremony = ? AND expires_at > ?", challengeID, userId, ceremony, time.Now()).
+		First(&challenge).Error
+	if err != nil {
+		return nil, ErrChallengeNotFound
+	}
+	result := userDB.Delete(&challenge)
+	if result.Error != nil || result.RowsAffected != 1 {
+		// somebody else finished the same ceremony first
+		return nil, ErrChallengeNotFound
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(challenge.Data), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SavePasskeyCredential stores a credential created by a registration ceremony.
+func SavePasskeyCredential(userId string, name string, credential *webauthn.Credential) (models.PasskeyCredential, error) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return models.PasskeyCredential{}, err
+	}
+	row := models.PasskeyCredential{
+		Credential_id: base64.RawURLEncoding.EncodeToString(credential.ID),
+		User_id:       userId,
+		Name:          truncate(name, 100),
+		Data:          string(data),
+		Sign_count:    credential.Authenticator.SignCount,
+		Created_at:    time.Now(),
+	}
+	err = userDB.Create(&row).Error
+	return row, err
+}
+
+// UpdatePasskeyCredential stores the sign counter (and flags) after a login.
+func UpdatePasskeyCredential(userId string, credential *webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+	return userDB.Model(&models.PasskeyCredential{}).
+		Where("credential_id = ? AND user_id = ?", base64.RawURLEncoding.EncodeToString(credential.ID), userId).
+		Updates(map[string]interface{}{
+			"data":         string(data),
+			"sign_count":   credential.Authenticator.SignCount,
+			"last_used_at": time.Now(),
+		}).Error
+}
+
+// DeletePasskeyCredential removes a passkey of the user. Like UnlinkIdentity it
+// refuses when that would leave an account without a password no way to log in.
+func DeletePasskeyCredential(user models.User, credentialID string) error {
+	var row models.PasskeyCredential
+	if err := userDB.Where("credential_id = ? AND user_id = ?", credentialID, user.User_id).First(&row).Error; err != nil {
+		return ErrPasskeyNotFound
+	}
+
+	if user.Password == nil {
+		var identities, others int64
+		userDB.Model(&models.UserIdentity{}).Where("user_id = ? AND linked_at IS NOT NULL", user.User_id).Count(&identities)
+		userDB.Model(&models.PasskeyCredential{}).Where("user_id = ? AND id <> ?", user.User_id, row.ID).Count(&others)
+		if identities == 0 && others == 0 {
+			return ErrLastLoginMethod
+		}
+	}
+	return userDB.Delete(&row).Error
+}
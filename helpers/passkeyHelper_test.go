@@ -0,0 +1,38 @@
+package helpers_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/database/databasetest"
+	helper "github.com/Aaryansingh20/jwt/helpers"
+	"github.com/Aaryansingh20/jwt/models"
+)
+
+func TestTheLastPasskeyOfAPasswordlessAccountStays(t *testing.T) {
+	db := databasetest.Setup(t)
+	email := "ada@example.com"
+	user := models.User{User_id: "user-1", Email: &email}
+	for _, id := range []string{"key-1", "key-2"} {
+		if err := db.Create(&models.PasskeyCredential{Credential_id: id, User_id: user.User_id, Created_at: time.Now()}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := helper.DeletePasskeyCredential(user, "key-1"); err != nil {
+		t.Fatalf("one of two passkeys: %v", err)
+	}
+	if err := helper.DeletePasskeyCredential(user, "key-1"); !errors.Is(err, helper.ErrPasskeyNotFound) {
+		t.Fatalf("want ErrPasskeyNotFound, got %v", err)
+	}
+	if err := helper.DeletePasskeyCredential(user, "key-2"); !errors.Is(err, helper.ErrLastLoginMethod) {
+		t.Fatalf("want ErrLastLoginMethod for the last passkey, got %v", err)
+	}
+
+	password := "some-hash"
+	user.Password = &password
+	if err := helper.DeletePasskeyCredential(user, "key-2"); err != nil {
+		t.Fatalf("with a password the last passkey may go: %v", err)
+	}
+}
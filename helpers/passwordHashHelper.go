@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into self describing strings (PHC strings for
+// argon2id, the usual $2a$ format for bcrypt), so every stored hash carries its
+// algorithm and parameters and can be checked after the config changed.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, false for a hash of another algorithm.
+	Verify(password string, encoded string) bool
+	// Owns reports whether encoded was made by this algorithm.
+	Owns(encoded string) bool
+	// NeedsRehash reports whether encoded was made with other parameters than the current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher is what every password was hashed with before argon2id.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hashed), err
+}
+
+func (h BcryptHasher) Verify(password string, encoded string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h BcryptHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher writes $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<hash>,
+// salt and hash in unpadded standard base64 like the reference implementation.
+type Argon2idHasher struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	Salt_length uint32
+	Key_length  uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.Salt_length)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.Key_length)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h Argon2idHasher) Verify(password string, encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1
+}
+
+func (h Argon2idHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory != h.Memory || params.iterations != h.Iterations || params.parallelism != h.Parallelism ||
+		uint32(len(params.salt)) != h.Salt_length || uint32(len(params.key)) != h.Key_length
+}
+
+func parseArgon2id(encoded string) (argon2idParams, error) {
+	var params argon2idParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, ErrUnknownPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return params, ErrUnknownPasswordHash
+	}
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, ErrUnknownPasswordHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return params, ErrUnknownPasswordHash
+	}
+	return params, nil
+}
+
+// PasswordHashAlgorithm is the algorithm new hashes are made with, PASSWORD_HASH_ALG
+// is argon2id (default) or bcrypt. The parameters come from
+//
+//	ARGON2_MEMORY (KiB, default 65536), ARGON2_ITERATIONS (3), ARGON2_PARALLELISM (2)
+//	BCRYPT_COST (default 12)
+var PasswordHashAlgorithm = func() string {
+	if alg := strings.ToLower(os.Getenv("PASSWORD_HASH_ALG")); alg == "bcrypt" {
+		return alg
+	}
+	return "argon2id"
+}()
+
+var (
+	bcryptHasher = BcryptHasher{Cost: EnvInt("BCRYPT_COST", 12)}
+
+	argon2idHasher = Argon2idHasher{
+		Memory:      uint32(EnvInt("ARGON2_MEMORY", 64*1024)),
+		Iterations:  uint32(EnvInt("ARGON2_ITERATIONS", 3)),
+		Parallelism: uint8(EnvInt("ARGON2_PARALLELISM", 2)),
+		Salt_length: 16,
+		Key_length:  32,
+	}
+
+	// every hasher we can still verify, new hashes use the current one
+	passwordHashers = []PasswordHasher{argon2idHasher, bcryptHasher}
+)
+
+func currentPasswordHasher() PasswordHasher {
+	if PasswordHashAlgorithm == "bcrypt" {
+		return bcryptHasher
+	}
+	return argon2idHasher
+}
+
+func init() {
+	if bcryptHasher.Cost < bcrypt.MinCost || bcryptHasher.Cost > bcrypt.MaxCost {
+		log.Fatalf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if argon2idHasher.Memory < 8*uint32(argon2idHasher.Parallelism) || argon2idHasher.Iterations < 1 || argon2idHasher.Parallelism < 1 {
+		log.Fatal("ARGON2_MEMORY, ARGON2_ITERATIONS or ARGON2_PARALLELISM is invalid")
+	}
+}
+
+// HashPassword hashes a password with the current algorithm and parameters.
+// It runs in the hashing pool, so it can fail with ErrHashPoolBusy.
+func HashPassword(password string) (hashed string, err error) {
+	poolErr := withHashSlot(func() {
+		hashed, err = currentPasswordHasher().Hash(password)
+	})
+	if poolErr != nil {
+		return "", poolErr
+	}
+	return hashed, err
+}
+
+// VerifyPassword checks a password against a stored hash of any algorithm we support.
+// Like HashPassword it runs in the hashing pool and can fail with ErrHashPoolBusy.
+func VerifyPassword(password string, encoded string) (bool, error) {
+	for _, hasher := range passwordHashers {
+		if hasher.Owns(encoded) {
+			var ok bool
+			err := withHashSlot(func() {
+				ok = hasher.Verify(password, encoded)
+			})
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// PasswordNeedsRehash reports whether a stored hash is of another algorithm or made
+// with other parameters than the current ones, so it should be replaced on the next login.
+func PasswordNeedsRehash(encoded string) bool {
+	current := currentPasswordHasher()
+	return !current.Owns(encoded) || current.NeedsRehash(encoded)
+}
@@ -0,0 +1,188 @@
+package helpers
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after 72 bytes, a longer password would only look stronger.
+// argon2id takes it all, the limit only keeps hashing cheap to ask for.
+const (
+	bcryptMaxPasswordBytes   = 72
+	argon2idMaxPasswordBytes = 1024
+)
+
+// PasswordPolicy is what a new password has to satisfy, see CheckPassword.
+type PasswordPolicy struct {
+	Min_length     int
+	Max_length     int // in bytes
+	Require_upper  bool
+	Require_lower  bool
+	Require_digit  bool
+	Require_symbol bool
+	Breached_file  string // optional, SHA-1 hashes of known breached passwords
+}
+
+// CurrentPasswordPolicy is configured from the environment:
+//
+//	PASSWORD_MIN_LENGTH      default 8
+//	PASSWORD_MAX_LENGTH      default 72 (bytes), at most 72 with bcrypt and 1024 with argon2id
+//	PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL
+//	PASSWORD_BREACHED_FILE   one SHA-1 hex hash per line, "HASH" or "HASH:COUNT" like the HIBP dumps
+var CurrentPasswordPolicy = func() PasswordPolicy {
+	policy := PasswordPolicy{
+		Min_length:     EnvInt("PASSWORD_MIN_LENGTH", 8),
+		Max_length:     EnvInt("PASSWORD_MAX_LENGTH", bcryptMaxPasswordBytes),
+		Require_upper:  EnvBool("PASSWORD_REQUIRE_UPPER"),
+		Require_lower:  EnvBool("PASSWORD_REQUIRE_LOWER"),
+		Require_digit:  EnvBool("PASSWORD_REQUIRE_DIGIT"),
+		Require_symbol: EnvBool("PASSWORD_REQUIRE_SYMBOL"),
+		Breached_file:  os.Getenv("PASSWORD_BREACHED_FILE"),
+	}
+	limit := argon2idMaxPasswordBytes
+	if PasswordHashAlgorithm == "bcrypt" {
+		limit = bcryptMaxPasswordBytes
+	}
+	if policy.Max_length <= 0 || policy.Max_length > limit {
+		policy.Max_length = limit
+	}
+	return policy
+}()
+
+// CheckPassword returns every rule the password breaks, nil if it is fine.
+// personal is what must not show up in it, like the email and the names of the user.
+func (policy PasswordPolicy) CheckPassword(password string, personal ...string) []string {
+	var problems []string
+
+	if len([]rune(password)) < policy.Min_length {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", policy.Min_length))
+	}
+	if len(password) > policy.Max_length {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes long", policy.Max_length))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if policy.Require_upper && !hasUpper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if policy.Require_lower && !hasLower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if policy.Require_digit && !hasDigit {
+		problems = append(problems, "must contain a digit")
+	}
+	if policy.Require_symbol && !hasSymbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	for _, value := range personal {
+		value = strings.ToLower(strings.TrimSpace(value))
+		// only the part before the @ of an email, "gmail" is nobody's personal info
+		if at := strings.Index(value, "@"); at >= 0 {
+			value = value[:at]
+		}
+		// very short names would rule out half the dictionary
+		if len([]rune(value)) >= 3 && strings.Contains(lowered, value) {
+			problems = append(problems, "must not contain your name or email")
+			break
+		}
+	}
+
+	if policy.Breached_file != "" && breached.contains(password) {
+		problems = append(problems, "has appeared in a data breach, please choose another one")
+	}
+	return problems
+}
+
+// breachedList is the sorted set of SHA-1 hashes from a breached password file.
+// 20 bytes an entry, so it is meant for a trimmed list (say the most common few
+// million hashes of the HIBP dump), not the whole thing.
+type breachedList struct {
+	hashes [][sha1.Size]byte
+}
+
+// breached is the list of CurrentPasswordPolicy, see LoadBreachedPasswords.
+var breached *breachedList
+
+// LoadBreachedPasswords reads PASSWORD_BREACHED_FILE, it is called once at startup.
+// A configured file that can't be read is an error: running on without the list
+// would quietly accept the passwords it is there to reject.
+func LoadBreachedPasswords() error {
+	path := CurrentPasswordPolicy.Breached_file
+	if path == "" {
+		return nil
+	}
+	list, err := loadBreachedList(path)
+	if err != nil {
+		return fmt.Errorf("loading PASSWORD_BREACHED_FILE: %w", err)
+	}
+	breached = list
+	log.Printf("Loaded %d breached password hashes", len(list.hashes))
+	return nil
+}
+
+func loadBreachedList(path string) (*breachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &breachedList{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if colon := strings.Index(line, ":"); colon >= 0 {
+			line = line[:colon]
+		}
+		var hash [sha1.Size]byte
+		if len(line) != 2*sha1.Size {
+			continue
+		}
+		if _, err := hex.Decode(hash[:], []byte(line)); err != nil {
+			continue
+		}
+		list.hashes = append(list.hashes, hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list.hashes, func(i, j int) bool {
+		return bytes.Compare(list.hashes[i][:], list.hashes[j][:]) < 0
+	})
+	return list, nil
+}
+
+// contains is false on a nil list, before LoadBreachedPasswords ran.
+func (list *breachedList) contains(password string) bool {
+	if list == nil {
+		return false
+	}
+	hash := sha1.Sum([]byte(password))
+	i := sort.Search(len(list.hashes), func(i int) bool {
+		return bytes.Compare(list.hashes[i][:], hash[:]) >= 0
+	})
+	return i < len(list.hashes) && list.hashes[i] == hash
+}
@@ -0,0 +1,84 @@
+package helpers
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPassword(t *testing.T) {
+	policy := PasswordPolicy{
+		Min_length:     10,
+		Max_length:     bcryptMaxPasswordBytes,
+		Require_upper:  true,
+		Require_lower:  true,
+		Require_digit:  true,
+		Require_symbol: true,
+	}
+	personal := []string{"ada.lovelace@example.com", "Ada", "Lovelace"}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"Analytical-Engine-1843", nil},
+		{"Sh0rt!", []string{"at least 10 characters"}},
+		{"all-lower-case-1", []string{"uppercase letter"}},
+		{"ALL-UPPER-CASE-1", []string{"lowercase letter"}},
+		{"No-Digits-At-All", []string{"digit"}},
+		{"NoSymbolsAtAll1", []string{"symbol"}},
+		{"I-am-LOVELACE-1815", []string{"name or email"}},
+		{"Ada.Lovelace-1815", []string{"name or email"}},
+		{strings.Repeat("Aa1!", 19), []string{"at most 72 bytes"}},
+	}
+	for _, test := range tests {
+		problems := policy.CheckPassword(test.password, personal...)
+		if len(problems) != len(test.want) {
+			t.Errorf("%q: want %d problems, got %v", test.password, len(test.want), problems)
+			continue
+		}
+		for i, want := range test.want {
+			if !strings.Contains(problems[i], want) {
+				t.Errorf("%q: want a problem about %q, got %q", test.password, want, problems[i])
+			}
+		}
+	}
+	// a two letter name is in plenty of words, it doesn't count
+	if problems := policy.CheckPassword("Limelight-Lift-9", "Li"); len(problems) != 0 {
+		t.Errorf("a very short name ruled the password out: %v", problems)
+	}
+}
+
+func TestBreachedPasswordsAreRefused(t *testing.T) {
+	sum := sha1.Sum([]byte("Tr0ub4dor&3"))
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// the HIBP format, upper case with a count, and a line that is no hash
+	content := "not a hash\n" + strings.ToUpper(hex.EncodeToString(sum[:])) + ":3303003\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, savedList := CurrentPasswordPolicy, breached
+	t.Cleanup(func() { CurrentPasswordPolicy, breached = saved, savedList })
+	CurrentPasswordPolicy.Breached_file = path
+	if err := LoadBreachedPasswords(); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := CurrentPasswordPolicy.CheckPassword("Tr0ub4dor&3")
+	if len(problems) != 1 || !strings.Contains(problems[0], "data breach") {
+		t.Fatalf("a breached password was accepted: %v", problems)
+	}
+	if problems := CurrentPasswordPolicy.CheckPassword("Tr0ub4dor&4"); len(problems) != 0 {
+		t.Fatalf("a password that is not on the list was refused: %v", problems)
+	}
+
+	// a list that is configured but missing keeps the server from starting
+	CurrentPasswordPolicy.Breached_file = filepath.Join(t.TempDir(), "missing.txt")
+	if err := LoadBreachedPasswords(); err == nil {
+		t.Fatal("a missing breached password file was ignored")
+	}
+}
@@ -0,0 +1,230 @@
+package helpers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// revocationCache keeps the denylist in memory so ValidateToken does not hit
+// Postgres on every request. It is reloaded every revocationCacheTTL, which is
+// also how long a revocation made on another instance can take to show up here.
+type revocationCache struct {
+	mu           sync.RWMutex
+	jtis         map[string]time.Time // jti -> when the token expires
+	userCutoffs  map[string]int64     // uid -> tokens issued before this (unix microseconds) are revoked
+	globalCutoff int64
+	loadedAt     time.Time
+	// held while the tables are loaded, token checks only wait for it on the first load
+	reload sync.Mutex
+}
+
+var revocations = &revocationCache{}
+
+var revocationCacheTTL = EnvDuration("REVOCATION_CACHE_TTL", 30*time.Second)
+
+// IsTokenRevoked checks the claims against the denylist.
+// Cutoffs are compared in microseconds (Issued_us, Postgres keeps timestamps at that
+// precision), so a token minted right after a cutoff is accepted and one minted
+// right before is not. Tokens from before Issued_us only have iat in whole seconds,
+// they count as issued at the start of that second.
+func IsTokenRevoked(claims *SignedDetails) bool {
+	revocations.refreshIfStale()
+
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+
+	if claims.Id != "" {
+		if _, found := revocations.jtis[claims.Id]; found {
+			return true
+		}
+	}
+	// a revoked session is on the denylist under its id, see RevokeSession
+	if claims.Family_id != "" {
+		if _, found := revocations.jtis[claims.Family_id]; found {
+			return true
+		}
+	}
+	issued := claims.Issued_us
+	if issued == 0 {
+		issued = claims.IssuedAt * int64(time.Second/time.Microsecond)
+	}
+	return revocations.beforeCutoff(claims.Uid, issued)
+}
+
+// RevokedSince reports whether a login of the user at loggedInUs (unix microseconds)
+// is cut off like a token issued then would be. For logins that aren't tokens, like
+// the browser session at /authorize.
+func RevokedSince(userId string, loggedInUs int64) bool {
+	revocations.refreshIfStale()
+
+	revocations.mu.RLock()
+	defer revocations.mu.RUnlock()
+	return revocations.beforeCutoff(userId, loggedInUs)
+}
+
+// beforeCutoff checks issued against the cutoffs, the read lock must be held.
+func (cache *revocationCache) beforeCutoff(userId string, issued int64) bool {
+	if issued < cache.globalCutoff {
+		return true
+	}
+	if cutoff, found := cache.userCutoffs[userId]; found && issued < cutoff {
+		return true
+	}
+	return false
+}
+
+// RevokeToken puts a single token on the denylist until it expires.
+func RevokeToken(jti string, userId string, expiresAt time.Time) error {
+	revoked := models.RevokedToken{
+		Jti:        jti,
+		User_id:    userId,
+		Expires_at: expiresAt,
+		Created_at: time.Now(),
+	}
+	err := userDB.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+	if err != nil {
+		return err
+	}
+
+	revocations.mu.Lock()
+	if revocations.jtis != nil {
+		revocations.jtis[jti] = expiresAt
+	}
+	revocations.mu.Unlock()
+	return nil
+}
+
+// RevokeAllUserTokens revokes every token issued to the user so far.
+func RevokeAllUserTokens(userId string) error {
+	return revokeIssuedBefore(userId, time.Now())
+}
+
+// RevokeTokensIssuedBefore revokes every token of every user issued before t.
+func RevokeTokensIssuedBefore(t time.Time) error {
+	return revokeIssuedBefore(models.AllUsers, t)
+}
+
+func revokeIssuedBefore(userId string, t time.Time) error {
+	revocation := models.TokenRevocation{
+		User_id:        userId,
+		Revoked_before: t,
+		Updated_at:     time.Now(),
+	}
+	// a cutoff only ever moves forward, an older timestamp must not un-revoke anything.
+	// CASE rather than GREATEST, which the SQLite of the tests doesn't have.
+	err := userDB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "revoked_before"}, Value: gorm.Expr(
+				"CASE WHEN token_revocations.revoked_before > excluded.revoked_before THEN token_revocations.revoked_before ELSE excluded.revoked_before END",
+			)},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("excluded.updated_at")},
+		},
+	}).Create(&revocation).Error
+	if err != nil {
+		return err
+	}
+
+	revocations.mu.Lock()
+	defer revocations.mu.Unlock()
+	if userId == models.AllUsers {
+		if t.UnixMicro() > revocations.globalCutoff {
+			revocations.globalCutoff = t.UnixMicro()
+		}
+	} else if revocations.userCutoffs != nil && t.UnixMicro() > revocations.userCutoffs[userId] {
+		revocations.userCutoffs[userId] = t.UnixMicro()
+	}
+	return nil
+}
+
+func (cache *revocationCache) refreshIfStale() {
+	cache.mu.RLock()
+	fresh := time.Since(cache.loadedAt) < revocationCacheTTL
+	loaded := !cache.loadedAt.IsZero()
+	cache.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	// one request reloads, the others keep checking against the old maps meanwhile.
+	// Only before the first load there is nothing to check against, so they wait.
+	if !cache.reload.TryLock() {
+		if loaded {
+			return
+		}
+		cache.reload.Lock()
+	}
+	defer cache.reload.Unlock()
+	cache.mu.RLock()
+	fresh = time.Since(cache.loadedAt) < revocationCacheTTL
+	cache.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	jtis, userCutoffs, globalCutoff, err := loadRevocations()
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	// a failed load is tried again after the TTL, not on every request
+	cache.loadedAt = time.Now()
+	if err != nil {
+		// keep serving from the old cache, better than failing every request
+		log.Println("Error loading token revocations:", err)
+		return
+	}
+
+	// revocations made here while we were loading may be missing from the tables we
+	// read. The denylist only ever grows and cutoffs only move forward, so whatever
+	// the old maps have is kept.
+	now := time.Now()
+	for jti, expiresAt := range cache.jtis {
+		if _, found := jtis[jti]; !found && expiresAt.After(now) {
+			jtis[jti] = expiresAt
+		}
+	}
+	for uid, cutoff := range cache.userCutoffs {
+		if cutoff > userCutoffs[uid] {
+			userCutoffs[uid] = cutoff
+		}
+	}
+	cache.jtis = jtis
+	cache.userCutoffs = userCutoffs
+	cache.globalCutoff = max(cache.globalCutoff, globalCutoff)
+}
+
+// loadRevocations reads the denylist and the cutoffs from Postgres.
+func loadRevocations() (jtis map[string]time.Time, userCutoffs map[string]int64, globalCutoff int64, err error) {
+	// rows of tokens that are expired by now are useless, drop them on the way
+	if err := userDB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+		log.Println("Error purging expired revoked tokens:", err)
+	}
+
+	var revoked []models.RevokedToken
+	if err := userDB.Find(&revoked).Error; err != nil {
+		return nil, nil, 0, err
+	}
+	var cutoffs []models.TokenRevocation
+	if err := userDB.Find(&cutoffs).Error; err != nil {
+		return nil, nil, 0, err
+	}
+
+	jtis = make(map[string]time.Time, len(revoked))
+	for _, token := range revoked {
+		jtis[token.Jti] = token.Expires_at
+	}
+	userCutoffs = make(map[string]int64, len(cutoffs))
+	for _, cutoff := range cutoffs {
+		if cutoff.User_id == models.AllUsers {
+			globalCutoff = cutoff.Revoked_before.UnixMicro()
+			continue
+		}
+		userCutoffs[cutoff.User_id] = cutoff.Revoked_before.UnixMicro()
+	}
+	return jtis, userCutoffs, globalCutoff, nil
+}
@@ -0,0 +1,199 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Aaryansingh20/jwt/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ErrRefreshTokenReused is returned by RotateSession when the presented refresh token
+// was already exchanged. The session is revoked by then.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+
+// ErrSessionRevoked is returned by RotateSession for sessions that were ended.
+var ErrSessionRevoked = errors.New("the refresh token has been revoked")
+
+// ErrSessionNotFound is returned by RevokeUserSession when the user has no active
+// session of that id.
+var ErrSessionNotFound = errors.New("session not found")
+
+// CreateSession starts a new session for the user on the device making the request
+// and returns its first token pair. Other sessions of the user are left alone.
+func CreateSession(c *gin.Context, user models.User) (signedToken string, signedRefreshToken string, err error) {
+	deviceName := c.GetHeader("X-Device-Name")
+	if deviceName == "" {
+		deviceName = c.Request.UserAgent()
+	}
+	return createSession(c, user, uuid.New().String(), deviceName, "", "")
+}
+
+// CreateClientSession starts the session sessionID granted to an OAuth client with
+// scope. It shows up in the user's sessions under the client's name and can be
+// revoked there. The id is picked by the caller, see RedeemAuthorizationCode.
+func CreateClientSession(c *gin.Context, user models.User, client models.OAuthClient, scope string, sessionID string) (signedToken string, signedRefreshToken string, err error) {
+	return createSession(c, user, sessionID, client.Name, client.Client_id, scope)
+}
+
+func createSession(c *gin.Context, user models.User, sessionID string, deviceName string, clientID string, scope string) (signedToken string, signedRefreshToken string, err error) {
+	signedToken, signedRefreshToken, err = GenerateTokensForClient(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, sessionID, clientID, scope)
+	if err != nil {
+		return "", "", err
+	}
+
+	userAgent := c.Request.UserAgent()
+
+	now := time.Now()
+	session := models.Session{
+		Session_id:         sessionID,
+		User_id:            user.User_id,
+		Device_name:        truncate(deviceName, 100),
+		User_agent:         truncate(userAgent, 255),
+		Ip:                 c.ClientIP(),
+		Refresh_token_hash: HashToken(signedRefreshToken),
+		Client_id:          clientID,
+		Scope:              scope,
+		Created_at:         now,
+		Last_used_at:       now,
+	}
+	if err = userDB.Create(&session).Error; err != nil {
+		return "", "", err
+	}
+	return signedToken, signedRefreshToken, nil
+}
+
+// RotateSession swaps the refresh token of the session for a new pair. It only
+// succeeds when the presented token is still the current one, a token that was
+// already exchanged means it leaked, and the whole session is revoked.
+func RotateSession(presentedRefreshToken string, claims *SignedDetails, user models.User) (signedToken string, signedRefreshToken string, err error) {
+	presentedHash := HashToken(presentedRefreshToken)
+
+	var session models.Session
+	err = userDB.Where("refresh_token_hash = ?", presentedHash).First(&session).Error
+	if err != nil {
+		// not the current token of any session. if its session is still alive
+		// the token was already exchanged once, which only happens when it leaked
+		var count int64
+		userDB.Model(&models.Session{}).
+			Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", claims.Family_id, claims.Uid).
+			Count(&count)
+		if count == 0 {
+			return "", "", ErrSessionRevoked
+		}
+		if err := RevokeSession(claims.Uid, claims.Family_id); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+	if session.Revoked_at != nil || session.Session_id != claims.Family_id || session.User_id != claims.Uid {
+		return "", "", ErrSessionRevoked
+	}
+
+	// a client session keeps its client and scope across refreshes
+	signedToken, signedRefreshToken, err = GenerateTokensForClient(*user.Email, *user.First_name, *user.Last_name, *user.User_type, user.User_id, session.Session_id, session.Client_id, session.Scope)
+	if err != nil {
+		return "", "", err
+	}
+
+	// compare-and-swap on the hash, two requests racing with the same token can't both win
+	result := userDB.Model(&models.Session{}).
+		Where("session_id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.Session_id, presentedHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": HashToken(signedRefreshToken),
+			"last_used_at":       time.Now(),
+		})
+	if result.Error != nil {
+		return "", "", result.Error
+	}
+	if result.RowsAffected != 1 {
+		if err := RevokeSession(user.User_id, session.Session_id); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrRefreshTokenReused
+	}
+	return signedToken, signedRefreshToken, nil
+}
+
+// ListSessions returns the sessions of the user that are still active, newest first.
+func ListSessions(userId string) ([]models.Session, error) {
+	var sessions []models.Session
+	err := userDB.Where("user_id = ? AND revoked_at IS NULL", userId).
+		Order("last_used_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends one session: its refresh token can't be exchanged anymore and
+// the access tokens minted for it are put on the denylist. A session that is
+// already over is left alone.
+func RevokeSession(userId string, sessionID string) error {
+	_, err := revokeSession(userId, sessionID)
+	return err
+}
+
+// RevokeUserSession is RevokeSession for a session id the user sent, it returns
+// ErrSessionNotFound for ids that aren't an active session of theirs.
+func RevokeUserSession(userId string, sessionID string) error {
+	ended, err := revokeSession(userId, sessionID)
+	if err == nil && !ended {
+		return ErrSessionNotFound
+	}
+	return err
+}
+
+func revokeSession(userId string, sessionID string) (ended bool, err error) {
+	result := userDB.Model(&models.Session{}).
+		Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	// IsTokenRevoked checks the Family_id of a token against the denylist as well
+	return true, RevokeToken(sessionID, userId, time.Now().Add(MaxTokenLifetime))
+}
+
+// RevokeOtherSessions ends every session of the user but keepSessionID, with their
+// access tokens. Used when the user changes their password on one device.
+func RevokeOtherSessions(userId string, keepSessionID string) error {
+	var sessions []models.Session
+	err := userDB.Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userId, keepSessionID).
+		Find(&sessions).Error
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := RevokeSession(userId, session.Session_id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of the user. Their access tokens are not
+// denylisted one by one, pair it with RevokeAllUserTokens for that.
+func RevokeAllSessions(userId string) error {
+	return userDB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// truncate cuts value to at most size bytes for a column of that size. It cuts on a
+// rune boundary, and drops invalid UTF-8: Postgres refuses either in a text column
+// and the login would fail over a header.
+func truncate(value string, size int) string {
+	value = strings.ToValidUTF8(value, "")
+	if len(value) <= size {
+		return value
+	}
+	for size > 0 && !utf8.RuneStart(value[size]) {
+		size--
+	}
+	return value[:size]
+}
@@ -7,79 +7,156 @@ import (
 	"time"
 
 	"github.com/Aaryansingh20/jwt/database"
-	"github.com/Aaryansingh20/jwt/models"
 	jwt "github.com/dgrijalva/jwt-go" // golang driver for jwt
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// token types carried in the Token_type claim, so a refresh token can never be
+// used where an access token is expected (and the other way around).
+const (
+    AccessTokenType  = "access"
+    RefreshTokenType = "refresh"
+    // single-use tokens we email out, see IssueActionToken
+    EmailVerificationTokenType = "verify_email"
+    PasswordResetTokenType     = "password_reset"
+    MagicLinkTokenType         = "magic_link"
+    // handed out by Login when the password was right but a TOTP code is still needed
+    MfaPendingTokenType = "mfa_pending"
+)
+
+// who an access token speaks for, in the Principal_type claim. Tokens without it
+// are user tokens.
+const (
+    UserPrincipal   = "user"
+    ClientPrincipal = "client" // a machine client with the client_credentials grant, see GenerateClientToken
+)
+
+// how long the tokens we mint stay valid. MaxTokenLifetime is also how long a
+// retired signing key is kept around for verification.
+const (
+    AccessTokenLifetime  = time.Hour * time.Duration(120)
+    RefreshTokenLifetime = time.Hour * time.Duration(172)
+    MaxTokenLifetime     = RefreshTokenLifetime
+)
+
 type SignedDetails struct {
     Email      string
     First_name string
     Last_name  string
     Uid        string
     User_type  string
+    Token_type string
+    Family_id  string // the session the token belongs to, every refresh token of a session shares it
+    Issued_us  int64  `json:",omitempty"` // iat in microseconds, see IsTokenRevoked
+    // only set on tokens handed to an OAuth client, see GenerateTokensForClient
+    // and GenerateClientToken
+    Client_id      string `json:",omitempty"`
+    Scope          string `json:",omitempty"`
+    Principal_type string `json:",omitempty"`
     jwt.StandardClaims
 }
 
 var userDB *gorm.DB = database.Client
 
-// btw we should have our secret key in .env for production 
+// btw we should have our secret key in .env for production.
+// it is only used for signing when JWT_SIGNING_ALG is HS256, see keyHelper.go
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
+// GenerateAllTokens starts a brand new token family. The refresh token can only be
+// exchanged when the family is stored as a session, so logins go through CreateSession.
 func GenerateAllTokens(email string, firstName string, lastName string, userType string, uid string) (signedToken string, signedRefreshToken string, err error) {
+    return GenerateTokensForFamily(email, firstName, lastName, userType, uid, uuid.New().String())
+}
+
+// GenerateTokensForFamily mints a new access/refresh pair inside an existing family
+// (session), this is what the refresh endpoint uses to rotate the refresh token.
+func GenerateTokensForFamily(email string, firstName string, lastName string, userType string, uid string, familyID string) (signedToken string, signedRefreshToken string, err error) {
+    return GenerateTokensForClient(email, firstName, lastName, userType, uid, familyID, "", "")
+}
+
+// GenerateTokensForClient is GenerateTokensForFamily for a session of an OAuth client,
+// both tokens carry the client and the scope the user granted it. Empty for our own logins.
+func GenerateTokensForClient(email string, firstName string, lastName string, userType string, uid string, familyID string, clientID string, scope string) (signedToken string, signedRefreshToken string, err error) {
+    now := time.Now()
     claims := &SignedDetails{
         Email:      email,
         First_name: firstName,
         Last_name:  lastName,
         Uid:        uid,
         User_type:  userType,
+        Token_type: AccessTokenType,
+        Family_id:  familyID,
+        Client_id:  clientID,
+        Scope:      scope,
+        Issued_us:  now.UnixMicro(),
         StandardClaims: jwt.StandardClaims{
+            Id:       uuid.New().String(), // the jti, lets us revoke this one token
+            IssuedAt: now.Unix(),
             // setting the expiry time
-            ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(120)).Unix(),
+            ExpiresAt: now.Add(AccessTokenLifetime).Unix(),
         },
     }
     // refreshClaims is used to get a new token if the previous one is expired.
-
+    // it only carries what we need to find the user row again.
     refreshClaims := &SignedDetails{
+        Uid:        uid,
+        Token_type: RefreshTokenType,
+        Family_id:  familyID,
+        Client_id:  clientID,
+        Scope:      scope,
+        Issued_us:  now.UnixMicro(),
         StandardClaims: jwt.StandardClaims{
-            ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(172)).Unix(),
+            Id:        uuid.New().String(), // makes every rotated refresh token unique
+            IssuedAt:  now.Unix(),
+            ExpiresAt: now.Add(RefreshTokenLifetime).Unix(),
         },
     }
-    token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
-    refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+    token, err := signClaims(claims)
     if err != nil {
         log.Panic(err)
         return
     }
-    return token, refreshToken, err
-}
-
-func UpdateAllTokens(signedToken string, signedRefreshToken string, userId string) {
-    Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-    
-    err := userDB.Model(&models.User{}).
-        Where("user_id = ?", userId).
-        Updates(map[string]interface{}{
-            "token":         signedToken,
-            "refresh_token": signedRefreshToken,
-            "updated_at":    Updated_at,
-        }).Error
-    
+    refreshToken, err := signClaims(refreshClaims)
     if err != nil {
         log.Panic(err)
         return
     }
-    return
+    return token, refreshToken, err
 }
 
 func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+    claims, msg = parseToken(signedToken)
+    if msg != "" {
+        return
+    }
+    // a refresh token (or any other kind) must only ever be accepted by its own endpoint.
+    // Tokens from before Token_type existed are refused too, refresh tokens of that
+    // time look just the same, those users simply log in again.
+    if claims.Token_type != AccessTokenType {
+        return nil, "the token is invalid"
+    }
+    return claims, msg
+}
+
+// ValidateRefreshToken is the refresh endpoint counterpart of ValidateToken.
+func ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+    claims, msg = parseToken(signedToken)
+    if msg != "" {
+        return
+    }
+    if claims.Token_type != RefreshTokenType || claims.Uid == "" || claims.Family_id == "" {
+        return nil, "the refresh token is invalid"
+    }
+    return claims, msg
+}
+
+func parseToken(signedToken string) (claims *SignedDetails, msg string) {
     // this function is basically returning the token
     token, err := jwt.ParseWithClaims(
         signedToken,
         &SignedDetails{},
-        func(token *jwt.Token) (interface{}, error) {
-            return []byte(SECRET_KEY), nil
-        },
+        verificationKey,
     )
     if err != nil {
         msg = err.Error()
@@ -87,16 +164,19 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
     }
     // checking if the token is correct or not
     claims, ok := token.Claims.(*SignedDetails)
-    if !ok {
+    if !ok || !token.Valid {
         msg = fmt.Sprintf("the token is invalid")
-        msg = err.Error()
-        return
+        return nil, msg
     }
     // if the token is expired, give error message
     if claims.ExpiresAt < time.Now().Local().Unix() {
         msg = fmt.Sprintf("token has been expired")
-        msg = err.Error()
-        return
+        return nil, msg
+    }
+    // a valid signature is not enough, the token may have been revoked since
+    if IsTokenRevoked(claims) {
+        msg = fmt.Sprintf("token has been revoked")
+        return nil, msg
     }
     return claims, msg
 }
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer is the development and test backend. With a Dir every email is written
+// there as an .eml file (open it with any mail client), without one it goes to stdout:
+// the whole message with Full, otherwise only who it is for and the subject.
+type FileMailer struct {
+	Dir  string
+	Full bool
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+
+	if m.Dir == "" {
+		if !m.Full {
+			_, err := fmt.Fprintf(stdout, "----- email to %s: %q (body not shown, set MAIL_DRIVER=log or file to see it) -----\n", msg.To, msg.Subject)
+			return err
+		}
+		_, err := fmt.Fprintf(stdout, "----- email to %s -----\n%s\n----- end of email -----\n", msg.To, data)
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFileName(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o600)
+}
+
+// where FileMailer prints, the tests read it back
+var stdout io.Writer = os.Stdout
+
+func sanitizeFileName(value string) string {
+	out := []rune{}
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			out = append(out, r)
+		default:
+			out = append(out, '_')
+		}
+	}
+	return string(out)
+}
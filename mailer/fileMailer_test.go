@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerWritesEml(t *testing.T) {
+	dir := t.TempDir()
+	mailer := &FileMailer{Dir: dir}
+	if err := mailer.Send(context.Background(), Message{To: "ada@example.com", Subject: "hi", Text: "the secret token"}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 || !strings.HasSuffix(files[0], "-ada@example.com.eml") {
+		t.Fatalf("want one .eml file, got %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "the secret token") {
+		t.Error("the file is missing the body")
+	}
+}
+
+func TestFileMailerStdout(t *testing.T) {
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+
+	msg := Message{To: "ada@example.com", Subject: "Your login link", Text: "https://app.example.com/login/secret-token"}
+	if err := (&FileMailer{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "secret") || !strings.Contains(out.String(), "Your login link") {
+		t.Fatalf("the default output must show the subject but not the body:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := (&FileMailer{Full: true}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "login/secret-token") {
+		t.Fatalf("MAIL_DRIVER=log must show the body:\n%s", out.String())
+	}
+}
@@ -0,0 +1,149 @@
+// Package mailer sends the emails of the service (verification links and the like).
+// Handlers never talk to SMTP directly, they render a template and put the message
+// on the queue, see SendTemplate.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+)
+
+// Message is one email, Text and HTML are the two alternative bodies.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers a message, the queue takes care of retrying failures.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FROM is the sender of every email.
+var FROM = func() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}()
+
+// NewFromEnv picks the backend with MAIL_DRIVER:
+//
+//	smtp  SMTP_HOST, SMTP_PORT (587), SMTP_USERNAME, SMTP_PASSWORD
+//	file  every email is written to MAIL_DIR as an .eml file
+//	log   every email is printed to stdout, links and codes included
+//
+// Without MAIL_DRIVER only the recipient and the subject are printed: the bodies
+// hold login links and codes, and stdout tends to end up in a log collector.
+func NewFromEnv() Mailer {
+	switch os.Getenv("MAIL_DRIVER") {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		if os.Getenv("SMTP_HOST") == "" {
+			log.Fatal("SMTP_HOST must be set when MAIL_DRIVER is smtp")
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "file":
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir}
+	case "log":
+		return &FileMailer{Full: true}
+	default:
+		return &FileMailer{}
+	}
+}
+
+// buildMIME renders the message as a multipart/alternative email.
+func buildMIME(msg Message) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	}
+	for _, part := range parts {
+		if part.content == "" {
+			continue
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(partWriter)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var email bytes.Buffer
+	headers := [][2]string{
+		{"From", FROM},
+		{"To", msg.To},
+		{"Subject", encodeHeader(msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID()},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		// never let a value smuggle in extra headers
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(header[1])
+		fmt.Fprintf(&email, "%s: %s\r\n", header[0], value)
+	}
+	email.WriteString("\r\n")
+	email.Write(body.Bytes())
+	return email.Bytes(), nil
+}
+
+var mimeWordEncoder = mime.QEncoding
+
+func encodeHeader(value string) string {
+	return mimeWordEncoder.Encode("UTF-8", value)
+}
+
+func messageID() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	domain := "localhost"
+	if at := strings.LastIndex(FROM, "@"); at >= 0 {
+		domain = strings.Trim(FROM[at+1:], "> ")
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">"
+}
@@ -0,0 +1,97 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Enqueue when the mail backend can't keep up.
+var ErrQueueFull = errors.New("mail queue is full")
+
+// Queue sends messages in the background, so request handlers never wait on SMTP.
+// Failed sends are retried with exponential backoff (2s, 4s, 8s, ...).
+type Queue struct {
+	mailer      Mailer
+	jobs        chan job
+	workers     int
+	maxAttempts int
+	retryDelay  time.Duration // the first retry waits twice this, doubling after
+	once        sync.Once
+}
+
+type job struct {
+	msg     Message
+	attempt int
+}
+
+func NewQueue(mailer Mailer, size int, workers int, maxAttempts int) *Queue {
+	return &Queue{
+		mailer:      mailer,
+		jobs:        make(chan job, size),
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		retryDelay:  time.Second,
+	}
+}
+
+// Enqueue hands the message to the workers without blocking.
+func (q *Queue) Enqueue(msg Message) error {
+	q.once.Do(q.start)
+	select {
+	case q.jobs <- job{msg: msg, attempt: 1}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *Queue) start() {
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for j := range q.jobs {
+				q.deliver(j)
+			}
+		}()
+	}
+}
+
+func (q *Queue) deliver(j job) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err := q.mailer.Send(ctx, j.msg)
+	cancel()
+	if err == nil {
+		return
+	}
+
+	if j.attempt >= q.maxAttempts {
+		log.Printf("❌ Giving up on email %q to %s after %d attempts: %v", j.msg.Subject, j.msg.To, j.attempt, err)
+		return
+	}
+	backoff := time.Duration(1<<j.attempt) * q.retryDelay
+	log.Printf("Error sending email %q to %s (attempt %d), retrying in %s: %v", j.msg.Subject, j.msg.To, j.attempt, backoff, err)
+
+	// wait aside instead of in the worker, so one bad message doesn't hold up the rest
+	j.attempt++
+	time.AfterFunc(backoff, func() {
+		select {
+		case q.jobs <- j:
+		default:
+			log.Printf("❌ Dropping email %q to %s, the queue is full", j.msg.Subject, j.msg.To)
+		}
+	})
+}
+
+var defaultQueue = NewQueue(NewFromEnv(), 100, 2, 5)
+
+// SendTemplate renders template name for the recipient and queues it on the
+// backend picked by NewFromEnv.
+func SendTemplate(to string, name string, vars map[string]interface{}) error {
+	msg, err := Render(name, to, vars)
+	if err != nil {
+		return err
+	}
+	return defaultQueue.Enqueue(msg)
+}
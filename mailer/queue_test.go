@@ -0,0 +1,91 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyMailer fails the first failures sends, then records what it delivers.
+type flakyMailer struct {
+	mu        sync.Mutex
+	failures  int
+	attempts  int
+	delivered []Message
+	done      chan struct{}
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts++
+	if m.attempts <= m.failures {
+		if m.attempts == cap(m.done) {
+			close(m.done)
+		}
+		return errors.New("smtp is down")
+	}
+	m.delivered = append(m.delivered, msg)
+	close(m.done)
+	return nil
+}
+
+func newTestQueue(mailer Mailer, maxAttempts int) *Queue {
+	q := NewQueue(mailer, 10, 1, maxAttempts)
+	q.retryDelay = time.Millisecond
+	return q
+}
+
+func TestQueueRetriesFailedSends(t *testing.T) {
+	mailer := &flakyMailer{failures: 2, done: make(chan struct{})}
+	q := newTestQueue(mailer, 5)
+	if err := q.Enqueue(Message{To: "ada@example.com", Subject: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-mailer.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was never delivered")
+	}
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	if mailer.attempts != 3 || len(mailer.delivered) != 1 {
+		t.Fatalf("want 3 attempts and 1 delivery, got %d and %d", mailer.attempts, len(mailer.delivered))
+	}
+}
+
+func TestQueueGivesUp(t *testing.T) {
+	// done is closed on the last attempt, cap marks which one that is
+	mailer := &flakyMailer{failures: 100, done: make(chan struct{}, 3)}
+	q := newTestQueue(mailer, 3)
+	if err := q.Enqueue(Message{To: "ada@example.com", Subject: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-mailer.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not retried")
+	}
+	// long enough for a fourth attempt to show up if there was one
+	time.Sleep(50 * time.Millisecond)
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	if mailer.attempts != 3 {
+		t.Fatalf("want 3 attempts, got %d", mailer.attempts)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// no workers, nothing drains the queue
+	q := NewQueue(&flakyMailer{done: make(chan struct{})}, 1, 0, 1)
+	if err := q.Enqueue(Message{To: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(Message{To: "b@example.com"}); err != ErrQueueFull {
+		t.Fatalf("want ErrQueueFull, got %v", err)
+	}
+}
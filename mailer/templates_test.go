@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplatesRender(t *testing.T) {
+	vars := map[string]interface{}{
+		"Name":     "<Ada>",
+		"Link":     "https://app.example.com/verify?token=abc&x=1",
+		"Code":     "123456",
+		"Provider": "GitHub",
+		"ValidFor": "15 minutes",
+	}
+	tests := []struct {
+		name    string
+		subject string
+		has     []string
+	}{
+		{"verify_email", "Verify your email address", []string{"Link"}},
+		{"reset_password", "Reset your password", []string{"Link"}},
+		{"magic_link", "Your login link", []string{"Link", "Code"}},
+		{"link_identity", "Link your GitHub account", []string{"Code"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := Render(test.name, "ada@example.com", vars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.To != "ada@example.com" || msg.Subject != test.subject {
+				t.Errorf("got to %q, subject %q", msg.To, msg.Subject)
+			}
+			if !strings.Contains(msg.Text, "Hi <Ada>,") || !strings.Contains(msg.Text, "15 minutes") {
+				t.Errorf("text body is missing the name or validity:\n%s", msg.Text)
+			}
+			// the HTML part escapes what it is given
+			if strings.Contains(msg.HTML, "<Ada>") || !strings.Contains(msg.HTML, "&lt;Ada&gt;") {
+				t.Errorf("html body does not escape the name:\n%s", msg.HTML)
+			}
+			for _, key := range test.has {
+				value := vars[key].(string)
+				if !strings.Contains(msg.Text, value) {
+					t.Errorf("text body is missing the %s", key)
+				}
+				if !strings.Contains(msg.HTML, strings.ReplaceAll(value, "&", "&amp;")) {
+					t.Errorf("html body is missing the %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderUnknownTemplate(t *testing.T) {
+	if _, err := Render("nope", "ada@example.com", nil); err == nil {
+		t.Fatal("want an error for an unknown template")
+	}
+}
+
+func TestBuildMIMEHeaders(t *testing.T) {
+	data, err := buildMIME(Message{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Grüße", Text: "hi", HTML: "<p>hi</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := string(data)
+	if strings.Contains(email, "\r\nBcc:") {
+		t.Error("a header was smuggled in through the recipient")
+	}
+	if !strings.Contains(email, "Subject: =?UTF-8?q?Gr=C3=BC=C3=9Fe?=") {
+		t.Errorf("subject is not encoded:\n%s", email)
+	}
+	if !strings.Contains(email, "multipart/alternative") {
+		t.Error("not a multipart/alternative message")
+	}
+}
@@ -3,11 +3,15 @@
 package main
 
 import (
+	"expvar"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
-	controllers "github.com/Aaryansingh20/jwt/controllers"
 	database "github.com/Aaryansingh20/jwt/database"
+	helpers "github.com/Aaryansingh20/jwt/helpers"
+	middleware "github.com/Aaryansingh20/jwt/middleware"
 	models "github.com/Aaryansingh20/jwt/models"
 	routes "github.com/Aaryansingh20/jwt/routes"
 
@@ -16,9 +20,6 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/joho/godotenv"
 	"github.com/markbates/goth/gothic"
-	"github.com/ulule/limiter/v3"
-	ginLimiter "github.com/ulule/limiter/v3/drivers/middleware/gin"
-	memory "github.com/ulule/limiter/v3/drivers/store/memory"
 )
 
 func main() {
@@ -28,11 +29,18 @@ func main() {
 		log.Println("✅ Loaded .env file")
 	}
 
-	// Setup session store for Goth (Google OAuth)
+	if err := helpers.CheckTokenHashKey(); err != nil {
+		log.Fatal(err)
+	}
+	if err := helpers.LoadBreachedPasswords(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Setup session store for Goth (social logins)
+	// a known key would let anyone sign these cookies themselves
 	key := os.Getenv("JWT_SECRET")
 	if key == "" {
-		key = "secret-key-please-change-this-in-production"
-		log.Println("⚠️  Using default session key - please set JWT_SECRET in .env")
+		log.Fatal("JWT_SECRET must be set, it signs the session cookies")
 	}
 	
 	// Create session store with proper settings
@@ -46,31 +54,39 @@ func main() {
 	gothic.Store = store
 	log.Println("✅ Session store configured")
 
-	// Initialize Google OAuth
-	routes.SetupGoogleAuth()
-	log.Println("✅ Google OAuth initialized")
+	// Initialize the social login providers (Google, GitHub, ...)
+	routes.SetupOAuthProviders()
+	log.Println("✅ OAuth providers initialized")
 
 	// Connect to database
-	database.Client = database.DBinstance()
-	database.Client.AutoMigrate(&models.User{})
+	database.Use(database.DBinstance())
+	database.Client.AutoMigrate(models.Tables()...)
+	database.DropLegacyTokenColumns(database.Client)
+	database.ClearPlaceholderPasswords(database.Client)
+	database.DropGlobalRecoveryCodeIndex(database.Client)
 	log.Println("✅ Database connected")
 
+	// Reload the signing keys on SIGHUP, so a key dropped into JWT_KEYS_DIR
+	// (or rotated on another instance) is picked up without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := helpers.ReloadKeyRing(); err != nil {
+				log.Println("❌ Error reloading signing keys:", err)
+			}
+		}
+	}()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
 
-	// Rate limiting
-	rate, err := limiter.NewRateFromFormatted("60-M")
-	if err != nil {
-		log.Fatal(err)
-	}
-	memoryStore := memory.NewStore()
-	limiterMiddleware := ginLimiter.NewMiddleware(limiter.New(memoryStore, rate))
-
 	router := gin.New()
 	router.Use(gin.Logger())
-	router.Use(limiterMiddleware)
+	// Rate limiting, some routes add a stricter limit of their own
+	router.Use(middleware.RateLimit("60-M"))
 
 	// CORS Configuration
 	config := cors.DefaultConfig()
@@ -79,19 +95,29 @@ func main() {
 		"http://localhost:8000",
 	}
 	config.AllowCredentials = true
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "token", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "token", "Authorization", "X-Device-Name"}
 	config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
 	router.Use(cors.New(config))
 
 	// Regular auth routes
 	routes.AuthRoutes(router)
 	routes.UserRoutes(router)
+	routes.WellKnownRoutes(router)
 
-	// Google OAuth routes
-	routes.GoogleAuthRoutes(router)
+	// Social login routes
+	routes.OAuthRoutes(router)
 
-	// Google Auth Callback Handler (for frontend)
-	router.GET("/api/auth/google/callback", controllers.GoogleAuthCallback())
+	// OpenID Connect provider for our other apps
+	routes.OIDCRoutes(router)
+	if !helpers.OIDCEnabled() {
+		log.Println("⚠️  OpenID Connect provider disabled - it needs JWT_SIGNING_ALG set to RS256, ES256 or EdDSA")
+	}
+
+	// expvar metrics (password hashing pool, memstats), off unless METRICS_ENABLED
+	// because they are not behind any auth
+	if helpers.EnvBool("METRICS_ENABLED") {
+		router.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+	}
 
 	router.GET("/api-1", func(c *gin.Context) {
 		c.JSON(200, gin.H{"success": "Access granted for api-1"})
@@ -7,7 +7,20 @@ import (
 	helpers "github.com/Aaryansingh20/jwt/helpers"
 	"github.com/gin-gonic/gin"
 )
+
+// Authenticate lets in users with an access token of our own logins.
 func Authenticate() gin.HandlerFunc {
+    return authenticate("")
+}
+
+// AuthenticateWithScope is Authenticate for the routes machine clients may call too,
+// their client_credentials tokens are let in when they carry scope. Users go
+// through as with Authenticate, the handler checks what they may do.
+func AuthenticateWithScope(scope string) gin.HandlerFunc {
+    return authenticate(scope)
+}
+
+func authenticate(scope string) gin.HandlerFunc {
     return func(c *gin.Context) {
         authHeader := c.GetHeader("Authorization")
         if authHeader == "" {
@@ -33,11 +46,47 @@ func Authenticate() gin.HandlerFunc {
             return
         }
 
+        // a machine client acts as itself, there is no user behind the token
+        if claims.Principal_type == helpers.ClientPrincipal {
+            if scope == "" {
+                c.JSON(http.StatusForbidden, gin.H{"error": "client credentials can't be used for this route"})
+                c.Abort()
+                return
+            }
+            if !helpers.ScopeIncludes(claims.Scope, scope) {
+                c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+                c.JSON(http.StatusForbidden, gin.H{"error": "the token is missing the " + scope + " scope"})
+                c.Abort()
+                return
+            }
+
+            c.Set("principal_type", helpers.ClientPrincipal)
+            c.Set("client_id", claims.Client_id)
+            c.Set("scopes", claims.Scope)
+            c.Set("jti", claims.Id)
+            c.Set("expires_at", claims.ExpiresAt)
+
+            c.Next()
+            return
+        }
+
+        // tokens granted to an OAuth client for a user are for /userinfo, not for our own API
+        if claims.Client_id != "" {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "the token was issued to an OAuth client"})
+            c.Abort()
+            return
+        }
+
+        c.Set("principal_type", helpers.UserPrincipal)
         c.Set("email", claims.Email)
         c.Set("first_name", claims.First_name)
         c.Set("last_name", claims.Last_name)
         c.Set("uid", claims.Uid)
         c.Set("user_type", claims.User_type)
+        // needed to revoke the presented token on logout
+        c.Set("jti", claims.Id)
+        c.Set("expires_at", claims.ExpiresAt)
+        c.Set("session_id", claims.Family_id)
 
         c.Next()
     }
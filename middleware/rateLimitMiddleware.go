@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ulule/limiter/v3"
+	ginLimiter "github.com/ulule/limiter/v3/drivers/middleware/gin"
+	memory "github.com/ulule/limiter/v3/drivers/store/memory"
+)
+
+// RateLimit limits requests per client IP, formatted is the limiter format like
+// "60-M" (60 a minute). Every call gets its own counters, so a route can have a
+// stricter limit on top of the global one.
+func RateLimit(formatted string) gin.HandlerFunc {
+    rate, err := limiter.NewRateFromFormatted(formatted)
+    if err != nil {
+        log.Fatal(err)
+    }
+    return ginLimiter.NewMiddleware(limiter.New(memory.NewStore(), rate))
+}
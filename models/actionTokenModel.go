@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// ActionToken backs the single-use links we email out (email verification and the
+// like). The link itself is a signed token, this row is what makes it single use.
+type ActionToken struct {
+    ID         uint       `gorm:"primaryKey" json:"-"`
+    Token_id   string     `json:"-" gorm:"size:100;uniqueIndex;not null"` // the jti of the signed token
+    User_id    string     `json:"-" gorm:"size:100;index;not null"`
+    Purpose    string     `json:"-" gorm:"size:50;index;not null"`
+    Expires_at time.Time  `json:"-"`
+    Used_at    *time.Time `json:"-"`
+    Code_hash  *string    `json:"-" gorm:"size:64"` // short code that can be typed in instead of opening the link
+    Attempts   int        `json:"-" gorm:"not null;default:0"` // wrong codes tried against this row
+    Created_at time.Time  `json:"-"`
+}
+
+func (ActionToken) TableName() string {
+    return "action_tokens"
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// LoginAttempt counts the failed password logins of an email. It is keyed by the
+// (normalized) email and not the user, so unknown emails get locked exactly like
+// real accounts and the lock tells nothing about which emails exist.
+type LoginAttempt struct {
+    ID             uint       `gorm:"primaryKey" json:"-"`
+    Email          string     `json:"email" gorm:"size:100;uniqueIndex;not null"`
+    Failed_count   int        `json:"failed_count" gorm:"not null;default:0"`
+    Last_failed_at time.Time  `json:"last_failed_at"`
+    Locked_until   *time.Time `json:"locked_until"`
+}
+
+func (LoginAttempt) TableName() string {
+    return "login_attempts"
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// OAuthClient is an app that logs its users in through us (we are its OpenID
+// Connect provider). Public clients (SPAs, mobile apps) have no secret and must use PKCE.
+// Machine clients (our backend jobs) only have the client_credentials grant, they
+// act as themselves with the service scopes they were given.
+type OAuthClient struct {
+    ID                 uint      `gorm:"primaryKey" json:"-"`
+    Client_id          string    `json:"client_id" gorm:"size:100;uniqueIndex;not null"`
+    Client_secret_hash *string   `json:"-" gorm:"size:64"` // nil for public clients
+    Name               string    `json:"name" gorm:"size:100;not null"`
+    Redirect_uris      []string  `json:"redirect_uris" gorm:"serializer:json;type:text;not null"`
+    Scopes             string    `json:"scopes" gorm:"size:255;not null"` // the scopes it may ask for, space separated
+    Grant_types        string    `json:"grant_types" gorm:"size:100;not null;default:'authorization_code refresh_token'"` // space separated
+    Skip_consent       bool      `json:"skip_consent" gorm:"not null;default:false"` // our own apps, users aren't asked
+    Created_at         time.Time `json:"created_at"`
+    Updated_at         time.Time `json:"updated_at"`
+}
+
+func (OAuthClient) TableName() string {
+    return "oauth_clients"
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (client OAuthClient) Confidential() bool {
+    return client.Client_secret_hash != nil
+}
+
+// AllowsGrant reports whether the client may use the grant type at the token endpoint.
+func (client OAuthClient) AllowsGrant(grantType string) bool {
+    for _, allowed := range strings.Fields(client.Grant_types) {
+        if allowed == grantType {
+            return true
+        }
+    }
+    return false
+}
+
+// OAuthAuthorizationCode is the single-use code /authorize hands to a client,
+// only its hash is stored.
+type OAuthAuthorizationCode struct {
+    ID             uint       `gorm:"primaryKey" json:"-"`
+    Code_hash      string     `gorm:"size:64;uniqueIndex;not null"`
+    Client_id      string     `gorm:"size:100;index;not null"`
+    User_id        string     `gorm:"size:100;not null"`
+    Redirect_uri   string     `gorm:"type:text;not null"`
+    Scope          string     `gorm:"size:255;not null"`
+    Nonce          string     `gorm:"size:255"`
+    Code_challenge string     `gorm:"size:128"` // S256 of the PKCE verifier
+    Auth_time      time.Time
+    Expires_at     time.Time  `gorm:"index"`
+    Used_at        *time.Time
+    Session_id     string     `gorm:"size:100"` // the session the code was redeemed for
+    Created_at     time.Time
+}
+
+func (OAuthAuthorizationCode) TableName() string {
+    return "oauth_authorization_codes"
+}
+
+// OAuthConsent is what a user agreed to share with a client, they are only asked
+// again when the client wants more.
+type OAuthConsent struct {
+    ID         uint      `gorm:"primaryKey" json:"-"`
+    User_id    string    `json:"-" gorm:"size:100;not null;uniqueIndex:idx_oauth_consents_user_client"`
+    Client_id  string    `json:"client_id" gorm:"size:100;not null;uniqueIndex:idx_oauth_consents_user_client"`
+    Scope      string    `json:"scope" gorm:"size:255;not null"`
+    Created_at time.Time `json:"created_at"`
+    Updated_at time.Time `json:"updated_at"`
+}
+
+func (OAuthConsent) TableName() string {
+    return "oauth_consents"
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// OAuthLoginCode is the short-lived code a finished provider login redirects to
+// the frontend with, instead of the identity itself. Only its hash is stored, and
+// it can only be exchanged by the browser that went through the login (Binding_hash).
+type OAuthLoginCode struct {
+    ID           uint       `gorm:"primaryKey" json:"-"`
+    Code_hash    string     `gorm:"size:64;uniqueIndex;not null"`
+    Binding_hash string     `gorm:"size:64;not null"`
+    Data         string     `gorm:"type:text;not null"` // the verified identity as JSON
+    Expires_at   time.Time  `gorm:"index"`
+    Used_at      *time.Time
+    Created_at   time.Time
+}
+
+func (OAuthLoginCode) TableName() string {
+    return "oauth_login_codes"
+}
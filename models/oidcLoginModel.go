@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+)
+
+// OIDCLogin is a login at /authorize. The browser's oidc_session cookie only holds
+// a random handle for it, the row is looked up by the hash of the handle.
+type OIDCLogin struct {
+    ID          uint      `gorm:"primaryKey" json:"-"`
+    Handle_hash string    `gorm:"size:64;uniqueIndex;not null"`
+    User_id     string    `gorm:"size:100;index;not null"`
+    Auth_us     int64     `gorm:"not null"` // when the user logged in, in microseconds like the revocation cutoffs
+    Expires_at  time.Time `gorm:"index"`
+    Created_at  time.Time
+}
+
+func (OIDCLogin) TableName() string {
+    return "oidc_logins"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+)
+
+// PasskeyCredential is a WebAuthn credential (passkey) registered by a user.
+// Data is the JSON of the library's webauthn.Credential, Sign_count is kept
+// apart so a cloned authenticator shows up in plain SQL too.
+type PasskeyCredential struct {
+    ID            uint       `gorm:"primaryKey" json:"-"`
+    Credential_id string     `json:"credential_id" gorm:"size:255;uniqueIndex;not null"` // base64url
+    User_id       string     `json:"-" gorm:"size:100;index;not null"`
+    Name          string     `json:"name" gorm:"size:100"`
+    Data          string     `json:"-" gorm:"type:text;not null"`
+    Sign_count    uint32     `json:"sign_count"`
+    Created_at    time.Time  `json:"created_at"`
+    Last_used_at  *time.Time `json:"last_used_at"`
+}
+
+func (PasskeyCredential) TableName() string {
+    return "passkey_credentials"
+}
+
+// PasskeyChallenge is the server side state of one registration or login ceremony.
+// It is deleted as soon as the ceremony finishes, so a challenge is never used twice.
+type PasskeyChallenge struct {
+    ID           uint      `gorm:"primaryKey" json:"-"`
+    Challenge_id string    `gorm:"size:100;uniqueIndex;not null"`
+    User_id      string    `gorm:"size:100;index"` // empty for passkey logins, the user isn't known yet
+    Ceremony     string    `gorm:"size:20;not null"`
+    Data         string    `gorm:"type:text;not null"` // webauthn.SessionData as JSON
+    Expires_at   time.Time `gorm:"index"`
+}
+
+func (PasskeyChallenge) TableName() string {
+    return "passkey_challenges"
+}
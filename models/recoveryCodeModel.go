@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// authenticator is lost. Only its hash is stored.
+type RecoveryCode struct {
+    ID         uint       `gorm:"primaryKey" json:"-"`
+    User_id    string     `json:"-" gorm:"size:100;not null;uniqueIndex:idx_recovery_codes_user_code"`
+    Code_hash  string     `json:"-" gorm:"size:128;not null;uniqueIndex:idx_recovery_codes_user_code"` // unique per user, two users may draw the same code
+    Used_at    *time.Time `json:"-"`
+    Created_at time.Time  `json:"-"`
+}
+
+func (RecoveryCode) TableName() string {
+    return "recovery_codes"
+}
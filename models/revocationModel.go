@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// RevokedToken is a single access or refresh token that must not be accepted
+// anymore, or a whole session when Jti holds a session id. The row is only
+// needed until the token would have expired anyway.
+type RevokedToken struct {
+    ID         uint      `gorm:"primaryKey" json:"id"`
+    Jti        string    `json:"jti" gorm:"size:100;uniqueIndex;not null"`
+    User_id    string    `json:"user_id" gorm:"size:100;index"`
+    Expires_at time.Time `json:"expires_at" gorm:"index"`
+    Created_at time.Time `json:"created_at"`
+}
+
+func (RevokedToken) TableName() string {
+    return "revoked_tokens"
+}
+
+// AllUsers is the User_id of the TokenRevocation that applies to every user.
+const AllUsers = "*"
+
+// TokenRevocation revokes every token of a user (or of everyone, see AllUsers)
+// that was issued before Revoked_before.
+type TokenRevocation struct {
+    ID             uint      `gorm:"primaryKey" json:"id"`
+    User_id        string    `json:"user_id" gorm:"size:100;uniqueIndex;not null"`
+    Revoked_before time.Time `json:"revoked_before"`
+    Updated_at     time.Time `json:"updated_at"`
+}
+
+func (TokenRevocation) TableName() string {
+    return "token_revocations"
+}
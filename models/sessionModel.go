@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is one logged in device of a user. Session_id is also the Family_id
+// claim of every token minted for it, and only the hash of the current refresh
+// token is stored, never the token itself.
+type Session struct {
+    ID                 uint       `gorm:"primaryKey" json:"-"`
+    Session_id         string     `json:"session_id" gorm:"size:100;uniqueIndex;not null"`
+    User_id            string     `json:"-" gorm:"size:100;index;not null"`
+    Device_name        string     `json:"device_name" gorm:"size:100"`
+    User_agent         string     `json:"user_agent" gorm:"size:255"`
+    Ip                 string     `json:"ip" gorm:"size:64"`
+    Refresh_token_hash string     `json:"-" gorm:"size:128;index"`
+    Client_id          string     `json:"client_id,omitempty" gorm:"size:100;index"` // the OAuth client the session was granted to, empty for our own logins
+    Scope              string     `json:"scope,omitempty" gorm:"size:255"`
+    Created_at         time.Time  `json:"created_at"`
+    Last_used_at       time.Time  `json:"last_used_at"`
+    Revoked_at         *time.Time `json:"-" gorm:"index"`
+}
+
+func (Session) TableName() string {
+    return "sessions"
+}
@@ -0,0 +1,11 @@
+package models
+
+// Tables are the models with a table of their own, in the order AutoMigrate
+// creates them.
+func Tables() []interface{} {
+    return []interface{}{
+        &User{}, &RevokedToken{}, &TokenRevocation{}, &Session{}, &ActionToken{}, &RecoveryCode{},
+        &PasskeyCredential{}, &PasskeyChallenge{}, &LoginAttempt{}, &UserIdentity{}, &OAuthLoginCode{},
+        &OAuthClient{}, &OAuthAuthorizationCode{}, &OAuthConsent{}, &OIDCLogin{},
+    }
+}
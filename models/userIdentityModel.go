@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity links an account of an external provider (Google and the like) to
+// a user. Linked_at is nil while the link waits for the user to prove they own
+// the account, such a row never logs anybody in.
+type UserIdentity struct {
+    ID               uint       `gorm:"primaryKey" json:"-"`
+    User_id          string     `json:"-" gorm:"size:100;index;not null;uniqueIndex:idx_user_identities_user_provider"`
+    Provider         string     `json:"provider" gorm:"size:50;not null;uniqueIndex:idx_user_identities_subject;uniqueIndex:idx_user_identities_user_provider"`
+    Provider_user_id string     `json:"-" gorm:"size:255;not null;uniqueIndex:idx_user_identities_subject"` // the subject (sub) at the provider
+    Email            string     `json:"email" gorm:"size:100"`                                                 // as the provider reported it when linking
+    Linked_at        *time.Time `json:"linked_at"`
+    Created_at       time.Time  `json:"created_at"`
+}
+
+func (UserIdentity) TableName() string {
+    return "user_identities"
+}
@@ -6,20 +6,25 @@ import (
 	"gorm.io/gorm"
 )
 
+// User is the database row. It is never sent to a client as is, see UserResponse,
+// and request bodies are bound to their own types in the controllers.
 type User struct {
-    ID            uint       `gorm:"primaryKey" json:"id"`
-    First_name    *string    `json:"first_name" validate:"required,min=2,max=100" gorm:"size:100;not null"`
-    Last_name     *string    `json:"last_name" validate:"required,min=2,max=100" gorm:"size:100;not null"`
-    Password      *string    `json:"password" validate:"required,min=6" gorm:"size:255;not null"`
-    Email         *string    `json:"email" validate:"email,required" gorm:"size:100;uniqueIndex;not null"` //validate email means it should have an @
-    Phone         *string    `json:"phone" validate:"required" gorm:"size:20;not null"`
-    Token         *string    `json:"token" gorm:"size:500"`
-    User_type     *string    `json:"user_type" validate:"required,eq=ADMIN|eq=USER" gorm:"size:20;not null"`
-    Refresh_token *string    `json:"refresh_token" gorm:"size:500"`
-    Created_at    time.Time  `json:"created_at"`
-    Updated_at    time.Time  `json:"updated_at"`
-    DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
-    User_id       string     `json:"user_id" gorm:"size:100;uniqueIndex;not null"`
+    ID                uint           `gorm:"primaryKey" json:"-"`
+    First_name        *string        `json:"first_name" gorm:"size:100;not null"`
+    Last_name         *string        `json:"last_name" gorm:"size:100;not null"`
+    Password          *string        `json:"-" gorm:"size:255"` // argon2id or bcrypt hash, must never leave the server. nil for social-only accounts
+    Email             *string        `json:"email" gorm:"size:100;uniqueIndex;not null"`
+    Phone             *string        `json:"phone" gorm:"size:20;not null"`
+    User_type         *string        `json:"user_type" gorm:"size:20;not null"`
+    Email_verified    bool           `json:"email_verified" gorm:"not null;default:false"`
+    Email_verified_at *time.Time     `json:"email_verified_at"`
+    Mfa_enabled       bool           `json:"mfa_enabled" gorm:"not null;default:false"`
+    Mfa_secret        *string        `json:"-" gorm:"size:255"` // encrypted TOTP secret, pending until Mfa_enabled
+    Mfa_last_step     int64          `json:"-" gorm:"not null;default:0"` // last TOTP step used, codes can't be replayed
+    Created_at        time.Time      `json:"created_at"`
+    Updated_at        time.Time      `json:"updated_at"`
+    DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
+    User_id           string         `json:"user_id" gorm:"size:100;uniqueIndex;not null"`
 }
 
 func (User) TableName() string {
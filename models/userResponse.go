@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+)
+
+// UserResponse is what any caller gets to see of a user. Build it with
+// NewUserResponse, never serialize a User directly: it holds the password hash.
+type UserResponse struct {
+    User_id        string    `json:"user_id"`
+    First_name     string    `json:"first_name"`
+    Last_name      string    `json:"last_name"`
+    Email          string    `json:"email"`
+    Phone          string    `json:"phone"`
+    User_type      string    `json:"user_type"`
+    Email_verified bool      `json:"email_verified"`
+    Created_at     time.Time `json:"created_at"`
+}
+
+// AdminUserResponse adds the bookkeeping fields only admins need.
+type AdminUserResponse struct {
+    UserResponse
+    ID         uint      `json:"id"`
+    Updated_at time.Time `json:"updated_at"`
+}
+
+// TokenResponse is returned by every endpoint that hands out a token pair.
+type TokenResponse struct {
+    Token         string       `json:"token"`
+    Refresh_token string       `json:"refresh_token"`
+    User          UserResponse `json:"user"`
+}
+
+func NewUserResponse(user User) UserResponse {
+    return UserResponse{
+        User_id:        user.User_id,
+        First_name:     deref(user.First_name),
+        Last_name:      deref(user.Last_name),
+        Email:          deref(user.Email),
+        Phone:          deref(user.Phone),
+        User_type:      deref(user.User_type),
+        Email_verified: user.Email_verified,
+        Created_at:     user.Created_at,
+    }
+}
+
+func NewAdminUserResponse(user User) AdminUserResponse {
+    return AdminUserResponse{
+        UserResponse: NewUserResponse(user),
+        ID:           user.ID,
+        Updated_at:   user.Updated_at,
+    }
+}
+
+func NewTokenResponse(token string, refreshToken string, user User) TokenResponse {
+    return TokenResponse{
+        Token:         token,
+        Refresh_token: refreshToken,
+        User:          NewUserResponse(user),
+    }
+}
+
+func deref(value *string) string {
+    if value == nil {
+        return ""
+    }
+    return *value
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	controllers "github.com/Aaryansingh20/jwt/controllers"
+	"github.com/Aaryansingh20/jwt/middleware"
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,4 +11,18 @@ import (
 func AuthRoutes(incomingRoutes *gin.Engine) {
     incomingRoutes.POST("users/signup", controllers.SignUp())
     incomingRoutes.POST("user/login", controllers.Login())
+    incomingRoutes.POST("users/login/mfa", controllers.LoginMFA())
+    // a stricter limit here, the 6 digit codes must not be guessable
+    emailLoginLimit := middleware.RateLimit(controllers.EmailLoginRateLimit)
+    incomingRoutes.POST("users/login/email-link", emailLoginLimit, controllers.RequestEmailLogin())
+    incomingRoutes.POST("users/login/email-link/redeem", emailLoginLimit, controllers.RedeemEmailLogin())
+    incomingRoutes.POST("users/identities/link", controllers.LinkIdentity())
+    incomingRoutes.POST("users/login/passkey/begin", controllers.BeginPasskeyLogin())
+    incomingRoutes.POST("users/login/passkey/finish", controllers.FinishPasskeyLogin())
+    // the access token may already be expired here, so this is not behind Authenticate
+    incomingRoutes.POST("users/refresh", controllers.RefreshToken())
+    incomingRoutes.POST("users/verify-email", controllers.VerifyEmail())
+    incomingRoutes.POST("users/verify-email/resend", controllers.ResendVerificationEmail())
+    incomingRoutes.POST("users/password/forgot", controllers.ForgotPassword())
+    incomingRoutes.POST("users/password/reset", controllers.ResetPassword())
 }
\ No newline at end of file
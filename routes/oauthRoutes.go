@@ -0,0 +1,39 @@
+// routes/oauthRoutes.go
+package routes
+
+import (
+	"log"
+
+	"github.com/Aaryansingh20/jwt/controllers"
+	"github.com/Aaryansingh20/jwt/helpers"
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+)
+
+// SetupOAuthProviders registers the social login providers configured in the
+// environment, see helpers.LoadOAuthProviders.
+func SetupOAuthProviders() {
+	providers, err := helpers.LoadOAuthProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, provider := range providers {
+		log.Printf("Login provider %s enabled", provider.Name())
+	}
+	goth.UseProviders(providers...)
+}
+
+func OAuthRoutes(router *gin.Engine) {
+	// Start the OAuth flow of a provider, like /auth/google
+	router.GET("/auth/:provider", controllers.OAuthBegin())
+
+	// Provider callback, the login is finished here and the frontend gets a code
+	router.GET("/auth/:provider/callback", controllers.OAuthCallback())
+
+	// the frontend trades that code for the token pair
+	router.POST("/auth/exchange", controllers.ExchangeOAuthCode())
+
+	// mobile apps and SPAs that got a Google ID token themselves
+	router.POST("/auth/google/token", controllers.GoogleTokenSignIn())
+}
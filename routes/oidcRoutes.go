@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/Aaryansingh20/jwt/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCRoutes are the endpoints of our OpenID Connect provider, the admin routes
+// for the clients are with the other protected routes.
+func OIDCRoutes(router *gin.Engine) {
+	// the token endpoint is for machine clients too, their tokens need no ID token
+	// key, Token checks it for the grants that do
+	router.POST("/token", controllers.Token())
+
+	oidc := router.Group("")
+	oidc.Use(controllers.RequireOIDC())
+
+	// the browser part: login, consent and the redirect back with a code
+	oidc.GET("/authorize", controllers.Authorize())
+	oidc.POST("/authorize", controllers.Authorize())
+	oidc.POST("/authorize/login", controllers.AuthorizeLogin())
+	oidc.POST("/authorize/consent", controllers.AuthorizeConsent())
+	oidc.GET("/logout", controllers.EndSession())
+	oidc.POST("/logout", controllers.EndSession())
+
+	// the client part
+	oidc.GET("/userinfo", controllers.UserInfo())
+	oidc.POST("/userinfo", controllers.UserInfo())
+}
@@ -7,6 +7,12 @@ import (
 )
 
 func UserRoutes(incomingRoutes *gin.Engine) {
+    // Routes our backend jobs call as well, with a client_credentials token that
+    // has the scope (see the token endpoint)
+    incomingRoutes.GET("/users", middleware.AuthenticateWithScope("users:read"), controllers.GetUsers())
+    incomingRoutes.GET("/users/:user_id", middleware.AuthenticateWithScope("users:read"), controllers.GetUserById())
+    incomingRoutes.POST("/users/:user_id/unlock", middleware.AuthenticateWithScope("users:write"), controllers.UnlockUser())
+
     // Create a group for protected routes
     userRoutes := incomingRoutes.Group("")
 
@@ -14,6 +20,23 @@ func UserRoutes(incomingRoutes *gin.Engine) {
     userRoutes.Use(middleware.Authenticate())
 
     // Protected routes
-    userRoutes.GET("/users", controllers.GetUsers())
-    userRoutes.GET("/users/:user_id", controllers.GetUserById())
+    userRoutes.POST("/keys/rotate", controllers.RotateSigningKey())
+    userRoutes.POST("/tokens/revoke", controllers.RevokeTokens())
+    userRoutes.POST("/users/logout", controllers.Logout())
+    userRoutes.POST("/users/logout-all", controllers.LogoutAll())
+    userRoutes.GET("/users/me/sessions", controllers.GetSessions())
+    userRoutes.DELETE("/users/me/sessions/:session_id", controllers.RevokeSession())
+    userRoutes.PUT("/users/me/password", controllers.ChangePassword())
+    userRoutes.POST("/users/me/mfa/totp", controllers.EnrollTOTP())
+    userRoutes.POST("/users/me/mfa/totp/confirm", controllers.ConfirmTOTP())
+    userRoutes.POST("/users/me/mfa/recovery-codes", controllers.RegenerateRecoveryCodes())
+    userRoutes.GET("/users/me/identities", controllers.GetIdentities())
+    userRoutes.DELETE("/users/me/identities/:provider", controllers.UnlinkIdentity())
+    userRoutes.POST("/users/me/passkeys/register/begin", controllers.BeginPasskeyRegistration())
+    userRoutes.POST("/users/me/passkeys/register/finish", controllers.FinishPasskeyRegistration())
+    userRoutes.GET("/users/me/passkeys", controllers.GetPasskeys())
+    userRoutes.DELETE("/users/me/passkeys/:credential_id", controllers.DeletePasskey())
+    userRoutes.POST("/oauth/clients", controllers.RegisterOAuthClient())
+    userRoutes.GET("/oauth/clients", controllers.GetOAuthClients())
+    userRoutes.DELETE("/oauth/clients/:client_id", controllers.DeleteOAuthClient())
 }
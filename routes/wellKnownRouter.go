@@ -0,0 +1,12 @@
+package routes
+
+import (
+	controllers "github.com/Aaryansingh20/jwt/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// public discovery documents, these never need a token.
+func WellKnownRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
+	incomingRoutes.GET("/.well-known/openid-configuration", controllers.RequireOIDC(), controllers.OpenIDConfiguration())
+}